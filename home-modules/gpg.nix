@@ -29,8 +29,11 @@ in {
     # make SSH/GPG prompts look like they are hanging inside TUIs or agent runs.
     grabKeyboardAndMouse = false;
     pinentry.package = pinentryPackage;
+    # keysync restore --preset-passphrase caches stored passphrases through
+    # gpg-preset-passphrase for non-interactive hosts.
     extraConfig = ''
       allow-loopback-pinentry
+      allow-preset-passphrase
     '';
   };
 
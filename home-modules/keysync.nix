@@ -4,6 +4,8 @@
   pkgs,
   ...
 }: let
+  cfg = config.programs.keysync;
+
   keysync = pkgs.buildGoModule {
     pname = "keysync";
     version = "0.1.0";
@@ -18,13 +20,107 @@
       license = licenses.mit;
     };
   };
+
+  inherit (lib) mkOption types;
+
+  # The option types are built from keysync.schema.json (keysync config
+  # schema), so they follow the config format without a second copy of it.
+  # Constraints across fields, such as a key needing a fingerprint or a spec,
+  # are left to keysync, which validates the result when it loads it.
+  schema = builtins.fromJSON (builtins.readFile ../keysync.schema.json);
+
+  resolve = s:
+    if s ? "$ref"
+    then schema."$defs".${lib.removePrefix "#/$defs/" s."$ref"} // removeAttrs s ["$ref"]
+    else s;
+
+  # Only anchored patterns describe the whole value, as builtins.match
+  # expects; \s is rewritten to its POSIX class.
+  stringType = s:
+    if s ? pattern && lib.hasPrefix "^" s.pattern && lib.hasSuffix "$" s.pattern
+    then types.strMatching (lib.replaceStrings ["\\s"] ["[:space:]"] (lib.removePrefix "^" (lib.removeSuffix "$" s.pattern)))
+    else types.str;
+
+  schemaType = s0: let
+    s = resolve s0;
+  in
+    if s ? enum
+    then types.enum s.enum
+    else if s.type == "object" && s ? properties
+    then types.submodule {options = lib.mapAttrs (name: schemaOption (builtins.elem name (s.required or []))) s.properties;}
+    else if s.type == "object"
+    then types.attrsOf (schemaType s.additionalProperties)
+    else if s.type == "array"
+    then types.listOf (schemaType s.items)
+    else if s.type == "string"
+    then stringType s
+    else if s.type == "integer"
+    then types.int
+    else if s.type == "boolean"
+    then types.bool
+    else throw "keysync.schema.json: unsupported type ${s.type}";
+
+  schemaOption = required: s:
+    mkOption ({
+        type =
+          if required
+          then schemaType s
+          else types.nullOr (schemaType s);
+        description = (resolve s).description or "";
+      }
+      // lib.optionalAttrs (!required) {default = null;});
+
+  # Drops unset options, including inside sops rules, so the JSON only holds
+  # what was configured.
+  clean = v:
+    if builtins.isAttrs v
+    then lib.mapAttrs (_: clean) (lib.filterAttrs (_: x: x != null) v)
+    else if builtins.isList v
+    then map clean v
+    else v;
+
+  settingsType = schemaType schema;
 in {
   imports = [
     ./gpg.nix
   ];
 
-  home.packages = with pkgs; [
-    keysync
-    _1password-cli
-  ];
+  options.programs.keysync = {
+    settings = mkOption {
+      type = types.nullOr settingsType;
+      default = null;
+      description = ''
+        keysync config as Nix. When set, it is written as JSON to
+        `configFile`; run keysync with `--config-nix` pointing at that file.
+      '';
+    };
+
+    finalSettings = mkOption {
+      type = types.nullOr types.attrs;
+      default =
+        if cfg.settings == null
+        then null
+        else clean cfg.settings;
+      readOnly = true;
+      description = "`settings` without the unset options, as written to `configFile`.";
+    };
+
+    configFile = mkOption {
+      type = types.str;
+      default = "${config.xdg.configHome}/keysync/keysync.json";
+      readOnly = true;
+      description = "Where the config generated from `settings` is written.";
+    };
+  };
+
+  config = {
+    home.packages = with pkgs; [
+      keysync
+      _1password-cli
+    ];
+
+    xdg.configFile."keysync/keysync.json" = lib.mkIf (cfg.settings != null) {
+      text = builtins.toJSON cfg.finalSettings;
+    };
+  };
 }
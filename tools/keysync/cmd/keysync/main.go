@@ -4,15 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/OnTheWehn333/keysync/internal/config"
 	"github.com/OnTheWehn333/keysync/internal/engine"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/nix"
 )
 
 var (
-	cfgFile string
+	cfgFile   string
+	configNix string
+	vaultFlag string
+	setFlags  []string
 )
 
 var rootCmd = &cobra.Command{
@@ -30,7 +37,7 @@ var syncCmd = &cobra.Command{
 			return &config.ConfigError{Msg: "exactly one of --host or --all is required"}
 		}
 
-		cfg, err := config.Load(cfgFile)
+		cfg, err := loadConfig()
 		if err != nil {
 			return err
 		}
@@ -52,7 +59,7 @@ var restoreCmd = &cobra.Command{
 			return &config.ConfigError{Msg: "--host is required"}
 		}
 
-		cfg, err := config.Load(cfgFile)
+		cfg, err := loadConfig()
 		if err != nil {
 			return err
 		}
@@ -60,11 +67,23 @@ var restoreCmd = &cobra.Command{
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		force, _ := cmd.Flags().GetBool("force")
 		verifyHash, _ := cmd.Flags().GetBool("verify-hash")
+		preset, _ := cmd.Flags().GetBool("preset-passphrase")
+		ssh, _ := cmd.Flags().GetBool("ssh")
+		sshTTL, _ := cmd.Flags().GetInt("ssh-ttl")
+		sshConfirm, _ := cmd.Flags().GetBool("ssh-confirm")
+		withPeers, _ := cmd.Flags().GetBool("with-peers")
 
 		return engine.Restore(cfg, hostName, engine.RestoreOpts{
-			DryRun:     dryRun,
-			Force:      force,
-			VerifyHash: verifyHash,
+			DryRun:           dryRun,
+			Force:            force,
+			VerifyHash:       verifyHash,
+			PresetPassphrase: preset,
+			SSH:              ssh,
+			SSHOpts: engine.SSHOpts{
+				TTL:     sshTTL,
+				Confirm: sshConfirm,
+			},
+			WithPeers: withPeers,
 		})
 	},
 }
@@ -79,7 +98,7 @@ var backupCmd = &cobra.Command{
 			return &config.ConfigError{Msg: "exactly one of --key or --all is required"}
 		}
 
-		cfg, err := config.Load(cfgFile)
+		cfg, err := loadConfig()
 		if err != nil {
 			return err
 		}
@@ -101,7 +120,7 @@ var backupRestoreCmd = &cobra.Command{
 			return &config.ConfigError{Msg: "--key is required"}
 		}
 
-		cfg, err := config.Load(cfgFile)
+		cfg, err := loadConfig()
 		if err != nil {
 			return err
 		}
@@ -109,17 +128,536 @@ var backupRestoreCmd = &cobra.Command{
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		force, _ := cmd.Flags().GetBool("force")
 		verifyHash, _ := cmd.Flags().GetBool("verify-hash")
+		preset, _ := cmd.Flags().GetBool("preset-passphrase")
 
 		return engine.BackupRestore(cfg, keyName, engine.RestoreOpts{
-			DryRun:     dryRun,
-			Force:      force,
-			VerifyHash: verifyHash,
+			DryRun:           dryRun,
+			Force:            force,
+			VerifyHash:       verifyHash,
+			PresetPassphrase: preset,
 		})
 	},
 }
 
+var initCmd = &cobra.Command{
+	Use:     "init",
+	Short:   "Scaffold a config from secret keys in the local keyring",
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyFlags, _ := cmd.Flags().GetStringArray("key")
+		hostName, _ := cmd.Flags().GetString("host")
+		vault := vaultFlag
+		add, _ := cmd.Flags().GetBool("add")
+
+		var keys []engine.InitKey
+		for _, value := range keyFlags {
+			name, fp, ok := strings.Cut(value, "=")
+			if !ok {
+				name, fp = "", value
+			}
+			keys = append(keys, engine.InitKey{Name: name, Fingerprint: fp})
+		}
+
+		stat, err := os.Stdin.Stat()
+		interactive := err == nil && stat.Mode()&os.ModeCharDevice != 0
+
+		return engine.Init(cfgFile, engine.InitOpts{
+			Keys:        keys,
+			Host:        hostName,
+			Vault:       vault,
+			Add:         add,
+			Interactive: interactive,
+			In:          os.Stdin,
+		})
+	},
+}
+
+var generateCmd = &cobra.Command{
+	Use:     "generate",
+	Short:   "Generate a key from its config spec, then back it up and sync it",
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyName, _ := cmd.Flags().GetString("key")
+		if keyName == "" {
+			return &config.ConfigError{Msg: "--key is required"}
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		return engine.Generate(cfg, cfgFile, keyName)
+	},
+}
+
+var hostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "Onboard and manage hosts",
+}
+
+var hostAddCmd = &cobra.Command{
+	Use:     "add <name>",
+	Short:   "Generate a host key, add it to the config and sync it",
+	Args:    cobra.ExactArgs(1),
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		uid, _ := cmd.Flags().GetString("uid")
+		algorithm, _ := cmd.Flags().GetString("algorithm")
+		expires, _ := cmd.Flags().GetString("expires")
+		refs, _ := cmd.Flags().GetStringSlice("ref")
+
+		return engine.AddHost(cfg, cfgFile, args[0], engine.HostAddOpts{
+			UID:       uid,
+			Algorithm: algorithm,
+			Expires:   expires,
+			Refs:      refs,
+		})
+	},
+}
+
+var hostRemoveCmd = &cobra.Command{
+	Use:     "remove <name>",
+	Short:   "Remove a host, archive its 1Password items and report leftover references",
+	Args:    cobra.ExactArgs(1),
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		revoke, _ := cmd.Flags().GetBool("revoke")
+		reasonName, _ := cmd.Flags().GetString("reason")
+		purge, _ := cmd.Flags().GetBool("purge")
+
+		reason, err := gpg.ParseRevocationReason(reasonName)
+		if err != nil {
+			return &config.ConfigError{Msg: err.Error()}
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		return engine.RemoveHost(cfg, cfgFile, args[0], engine.HostRemoveOpts{
+			Revoke: revoke,
+			Reason: reason,
+			Purge:  purge,
+		})
+	},
+}
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke a key or subkey, re-sync its items and export the revoked public key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyName, _ := cmd.Flags().GetString("key")
+		ref, _ := cmd.Flags().GetString("ref")
+		if (keyName == "" && ref == "") || (keyName != "" && ref != "") {
+			return &config.ConfigError{Msg: "exactly one of --key or --ref is required"}
+		}
+
+		reasonName, _ := cmd.Flags().GetString("reason")
+		reason, err := gpg.ParseRevocationReason(reasonName)
+		if err != nil {
+			return &config.ConfigError{Msg: err.Error()}
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		description, _ := cmd.Flags().GetString("description")
+		output, _ := cmd.Flags().GetString("output")
+
+		return engine.Revoke(cfg, engine.RevokeOpts{
+			KeyName:     keyName,
+			Ref:         ref,
+			Reason:      reason,
+			Description: description,
+			Output:      output,
+		})
+	},
+}
+
+var exportPublicCmd = &cobra.Command{
+	Use:   "export-public",
+	Short: "Export the public keys of configured keys for distribution",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hostName, _ := cmd.Flags().GetString("host")
+		exportAll, _ := cmd.Flags().GetBool("all")
+		if (hostName == "" && !exportAll) || (hostName != "" && exportAll) {
+			return &config.ConfigError{Msg: "exactly one of --host or --all is required"}
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+
+		return engine.ExportPublic(cfg, engine.ExportPublicOpts{
+			Host:   hostName,
+			Format: format,
+			Output: output,
+		})
+	},
+}
+
+var publishCmd = &cobra.Command{
+	Use:   "publish",
+	Short: "Upload a public key to an HKP keyserver and verify the published copy",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyName, _ := cmd.Flags().GetString("key")
+		if keyName == "" {
+			return &config.ConfigError{Msg: "--key is required"}
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		keyserver, _ := cmd.Flags().GetString("keyserver")
+		verify, _ := cmd.Flags().GetBool("verify")
+		checkOnly, _ := cmd.Flags().GetBool("check")
+
+		return engine.Publish(cfg, engine.PublishOpts{
+			KeyName:   keyName,
+			Keyserver: keyserver,
+			Verify:    verify,
+			CheckOnly: checkOnly,
+		})
+	},
+}
+
+var gitCmd = &cobra.Command{
+	Use:   "git",
+	Short: "Configure git to sign with host keys",
+}
+
+var gitConfigureCmd = &cobra.Command{
+	Use:   "configure",
+	Short: "Set the git signing key, gpg program and commit signing for a host",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hostName, _ := cmd.Flags().GetString("host")
+		if hostName == "" {
+			return &config.ConfigError{Msg: "--host is required"}
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		scope, _ := cmd.Flags().GetString("scope")
+		program, _ := cmd.Flags().GetString("gpg-program")
+		check, _ := cmd.Flags().GetBool("check")
+
+		return engine.GitConfigure(cfg, hostName, engine.GitConfigureOpts{
+			Scope:   scope,
+			Program: program,
+			Check:   check,
+		})
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Maintain the keysync config file",
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:     "migrate",
+	Short:   "Convert a version 1 config to version 2 roles",
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		return engine.MigrateConfig(cfg, cfgFile, dryRun)
+	},
+}
+
+var configLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Report every error and warning in the config, sorted by position",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if configNix != "" {
+			data, err := nix.ReadJSON(configNix)
+			if err != nil {
+				return err
+			}
+			return engine.LintConfig(configNix, data)
+		}
+		return engine.LintConfig(cfgFile, nil)
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the config JSON Schema, for editors and the YAML language server",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		if check, _ := cmd.Flags().GetBool("check"); check {
+			if output == "" {
+				output = filepath.Join(filepath.Dir(cfgFile), "keysync.schema.json")
+			}
+			return engine.CheckSchema(output)
+		}
+		return engine.WriteSchema(output)
+	},
+}
+
+var configRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Print the effective config after includes, ${ENV} expansion, title templates and overrides",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		return engine.RenderConfig(cfg)
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:     "set PATH VALUE",
+	Short:   "Set a config value, keeping comments and formatting",
+	Args:    cobra.ExactArgs(2),
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return engine.SetConfig(cfgFile, args[0], args[1])
+	},
+}
+
+var configAddCmd = &cobra.Command{
+	Use:     "add PATH VALUE...",
+	Short:   "Append values to a config list, keeping comments and formatting",
+	Args:    cobra.MinimumNArgs(2),
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return engine.AddConfig(cfgFile, args[0], args[1:])
+	},
+}
+
+var configRmCmd = &cobra.Command{
+	Use:     "rm PATH [VALUE...]",
+	Short:   "Remove a config entry, or values from a config list",
+	Args:    cobra.MinimumNArgs(1),
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return engine.RemoveConfig(cfgFile, args[0], args[1:])
+	},
+}
+
+var configRecoverCmd = &cobra.Command{
+	Use:     "recover",
+	Short:   "Rebuild the config's keys from the items tagged keysync in a vault",
+	PreRunE: requireConfigFile,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		vault := vaultFlag
+		if vault == "" {
+			return &config.ConfigError{Msg: "--vault is required"}
+		}
+		account, _ := cmd.Flags().GetString("account")
+		hostName, _ := cmd.Flags().GetString("host")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+
+		return engine.RecoverConfig(cfgFile, engine.RecoverOpts{
+			Vault:   vault,
+			Account: account,
+			Host:    hostName,
+			DryRun:  dryRun,
+			Force:   force,
+		})
+	},
+}
+
+var sshCmd = &cobra.Command{
+	Use:   "ssh",
+	Short: "Manage gpg-agent SSH access for auth subkeys",
+}
+
+var sshEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Add a host's auth subkeys to gpg-agent's sshcontrol",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hostName, _ := cmd.Flags().GetString("host")
+		if hostName == "" {
+			return &config.ConfigError{Msg: "--host is required"}
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		ttl, _ := cmd.Flags().GetInt("ttl")
+		confirm, _ := cmd.Flags().GetBool("confirm")
+
+		return engine.EnableSSH(cfg, hostName, engine.SSHOpts{
+			TTL:     ttl,
+			Confirm: confirm,
+		})
+	},
+}
+
+var nixCmd = &cobra.Command{
+	Use:   "nix",
+	Short: "Generate the hosts/shared Nix attrsets from the config and keyring",
+}
+
+var nixGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write the hosts/shared Nix attrsets",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		return engine.GenerateNix(cfg, nixDir(cmd))
+	},
+}
+
+var nixCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Fail if the committed hosts/shared Nix attrsets are out of date",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		return engine.CheckNix(cfg, nixDir(cmd))
+	},
+}
+
+// nixDir returns --dir, defaulting to hosts/shared next to the config file.
+func nixDir(cmd *cobra.Command) string {
+	dir, _ := cmd.Flags().GetString("dir")
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(cfgFile), "hosts", "shared")
+	}
+	return dir
+}
+
+var sopsCmd = &cobra.Command{
+	Use:   "sops",
+	Short: "Manage .sops.yaml creation rules and encrypted files from the config",
+}
+
+var sopsGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write .sops.yaml creation rules",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		return engine.GenerateSops(cfg, sopsFile(cmd))
+	},
+}
+
+var sopsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Fail if .sops.yaml differs from the config",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		return engine.CheckSops(cfg, sopsFile(cmd))
+	},
+}
+
+var sopsRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt sops files whose recipients changed",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// The previous config comes from the config file's git history,
+		// which a config from Nix does not have.
+		if from, _ := cmd.Flags().GetString("from"); configNix != "" && from == "" {
+			return &config.ConfigError{Msg: fmt.Sprintf("%s compares against the config file's git history; with --config-nix pass --from", cmd.CommandPath())}
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, _ := cmd.Flags().GetString("from")
+		rev, _ := cmd.Flags().GetString("rev")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+
+		return engine.RekeySops(cfg, cfgFile, sopsFile(cmd), engine.SopsRekeyOpts{
+			From:   from,
+			Rev:    rev,
+			DryRun: dryRun,
+		})
+	},
+}
+
+// loadConfig loads the config file with the --vault and --set overrides.
+func loadConfig() (*config.Config, error) {
+	var overrides []config.Override
+	if vaultFlag != "" {
+		overrides = append(overrides, config.Override{Path: "vault", Value: vaultFlag})
+	}
+	for _, value := range setFlags {
+		o, err := config.ParseOverride(value)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	if configNix != "" {
+		data, err := nix.ReadJSON(configNix)
+		if err != nil {
+			return nil, err
+		}
+		return config.LoadData(configNix, data, overrides)
+	}
+	return config.LoadWith(cfgFile, overrides)
+}
+
+// requireConfigFile refuses commands that rewrite the config file when the
+// config comes from --config-nix.
+func requireConfigFile(cmd *cobra.Command, args []string) error {
+	if configNix != "" {
+		return &config.ConfigError{Msg: fmt.Sprintf("%s rewrites the config file, but the config comes from --config-nix; change the Nix options instead", cmd.CommandPath())}
+	}
+	return nil
+}
+
+// sopsFile returns --file, defaulting to .sops.yaml next to the config file.
+func sopsFile(cmd *cobra.Command) string {
+	file, _ := cmd.Flags().GetString("file")
+	if file == "" {
+		file = filepath.Join(filepath.Dir(cfgFile), ".sops.yaml")
+	}
+	return file
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "keysync.yaml", "path to keysync config file")
+	rootCmd.PersistentFlags().StringVar(&configNix, "config-nix", "", "load the config as JSON from a flake attribute evaluated with nix eval --json, for example .#keysync, a .nix file or a JSON file")
+	rootCmd.PersistentFlags().StringVar(&vaultFlag, "vault", "", "1Password vault, overriding the config's")
+	rootCmd.PersistentFlags().StringArrayVar(&setFlags, "set", nil, "override a config value as PATH=VALUE, for example vault=Work (repeatable)")
 
 	syncCmd.Flags().String("host", "", "host name from keysync config")
 	syncCmd.Flags().Bool("all", false, "sync all unique host key references")
@@ -130,6 +668,11 @@ func init() {
 	restoreCmd.Flags().Bool("dry-run", false, "print what would be imported without importing")
 	restoreCmd.Flags().Bool("force", false, "delete and reimport keys")
 	restoreCmd.Flags().Bool("verify-hash", true, "verify sha256 hashes before importing")
+	restoreCmd.Flags().Bool("preset-passphrase", false, "cache the stored passphrase in gpg-agent (needs allow-preset-passphrase)")
+	restoreCmd.Flags().Bool("ssh", true, "add restored auth subkeys to gpg-agent sshcontrol")
+	restoreCmd.Flags().Int("ssh-ttl", 0, "sshcontrol cache TTL in seconds (0 for the agent default)")
+	restoreCmd.Flags().Bool("ssh-confirm", false, "require confirmation for each SSH use")
+	restoreCmd.Flags().Bool("with-peers", false, "also import the public keys of keys owned by other hosts")
 
 	backupCmd.Flags().String("key", "", "top-level key name from keysync config")
 	backupCmd.Flags().Bool("all", false, "backup all top-level keys")
@@ -139,16 +682,114 @@ func init() {
 	backupRestoreCmd.Flags().Bool("dry-run", false, "print what would be imported without importing")
 	backupRestoreCmd.Flags().Bool("force", false, "delete and reimport keys")
 	backupRestoreCmd.Flags().Bool("verify-hash", true, "verify sha256 hashes before importing")
+	backupRestoreCmd.Flags().Bool("preset-passphrase", false, "cache the stored passphrase in gpg-agent (needs allow-preset-passphrase)")
 
 	backupCmd.AddCommand(backupRestoreCmd)
 
+	initCmd.Flags().StringArray("key", nil, "primary fingerprint to add, optionally as NAME=FINGERPRINT (repeatable)")
+	initCmd.Flags().String("host", "", "host that references the new subkeys (default: this machine's host name)")
+	initCmd.Flags().Bool("add", false, "add the keys to an existing config")
+
+	generateCmd.Flags().String("key", "", "top-level key name with a spec in keysync config")
+	_ = generateCmd.MarkFlagRequired("key")
+
+	hostAddCmd.Flags().String("uid", "", "user ID for the new host key (default: host name)")
+	hostAddCmd.Flags().String("algorithm", "", "primary key algorithm (default: ed25519)")
+	hostAddCmd.Flags().String("expires", "", "key expiry, e.g. 2y (default: never)")
+	hostAddCmd.Flags().StringSlice("ref", nil, "key reference for the host, replacing the default of the roles or refs all hosts share (repeatable)")
+
+	hostRemoveCmd.Flags().Bool("revoke", false, "revoke the subkeys of keys used only by this host")
+	hostRemoveCmd.Flags().String("reason", "retired", "revocation reason: unspecified, compromised, superseded or retired")
+	hostRemoveCmd.Flags().Bool("purge", false, "delete 1Password items instead of archiving them")
+
+	revokeCmd.Flags().String("key", "", "top-level key name to revoke")
+	revokeCmd.Flags().String("ref", "", "key.subkey reference to revoke")
+	revokeCmd.Flags().String("reason", "unspecified", "revocation reason: unspecified, compromised, superseded or retired")
+	revokeCmd.Flags().String("description", "", "optional revocation description")
+	revokeCmd.Flags().String("output", "", "path for the revoked public key (default: <key>.revoked.asc)")
+
+	exportPublicCmd.Flags().String("host", "", "export the keys referenced by this host")
+	exportPublicCmd.Flags().Bool("all", false, "export every configured key")
+	exportPublicCmd.Flags().String("format", engine.ExportArmor, "output format: armor, binary, wkd or dir")
+	exportPublicCmd.Flags().String("output", "", "output file for armor and binary, directory for wkd and dir")
+
+	publishCmd.Flags().String("key", "", "top-level key name to publish")
+	_ = publishCmd.MarkFlagRequired("key")
+	publishCmd.Flags().String("keyserver", "", "HKP keyserver URL (default: keyserver from config, else hkps://keys.openpgp.org)")
+	publishCmd.Flags().Bool("verify", true, "fetch the published key and compare it with sha256_public")
+	publishCmd.Flags().Bool("check", false, "only verify the published key, without uploading")
+
+	gitConfigureCmd.Flags().String("host", "", "host name from keysync config")
+	_ = gitConfigureCmd.MarkFlagRequired("host")
+	gitConfigureCmd.Flags().String("scope", "global", "git config scope: global, system, local or worktree")
+	gitConfigureCmd.Flags().String("gpg-program", "", "gpg.program value (default: leave it unset so git runs the gpg on PATH)")
+	gitConfigureCmd.Flags().Bool("check", false, "report settings that differ without changing them")
+
+	gitCmd.AddCommand(gitConfigureCmd)
+
+	configMigrateCmd.Flags().Bool("dry-run", false, "print the migrated config without writing it")
+	configRecoverCmd.Flags().String("account", "", "1Password account of the vault (default: the signed-in account)")
+	configRecoverCmd.Flags().String("host", "", "stub host that references every recovered subkey (default: this machine's host name)")
+	configRecoverCmd.Flags().Bool("dry-run", false, "print the recovered config without writing it")
+	configRecoverCmd.Flags().Bool("force", false, "overwrite an existing config")
+	configSchemaCmd.Flags().String("output", "", "write the schema to this file instead of stdout")
+	configSchemaCmd.Flags().Bool("check", false, "fail if the schema file (default: keysync.schema.json next to the config) is out of date")
+
+	configCmd.AddCommand(configMigrateCmd)
+	configCmd.AddCommand(configLintCmd)
+	configCmd.AddCommand(configRecoverCmd)
+	configCmd.AddCommand(configRenderCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configAddCmd)
+	configCmd.AddCommand(configRmCmd)
+
+	sshEnableCmd.Flags().String("host", "", "host name from keysync config")
+	_ = sshEnableCmd.MarkFlagRequired("host")
+	sshEnableCmd.Flags().Int("ttl", 0, "sshcontrol cache TTL in seconds (0 for the agent default)")
+	sshEnableCmd.Flags().Bool("confirm", false, "require confirmation for each SSH use")
+
+	sshCmd.AddCommand(sshEnableCmd)
+
+	nixCmd.PersistentFlags().String("dir", "", "directory of the generated attrsets (default: hosts/shared next to the config)")
+	nixCmd.AddCommand(nixGenerateCmd)
+	nixCmd.AddCommand(nixCheckCmd)
+
+	sopsCmd.PersistentFlags().String("file", "", "path to .sops.yaml (default: next to the config)")
+	sopsCmd.AddCommand(sopsGenerateCmd)
+	sopsCmd.AddCommand(sopsCheckCmd)
+
+	sopsRekeyCmd.Flags().String("from", "", "previous config file to compare against (default: the config at --rev)")
+	sopsRekeyCmd.Flags().String("rev", "HEAD", "git revision of the previous config")
+	sopsRekeyCmd.Flags().Bool("dry-run", false, "print the recipient diff without rekeying")
+	sopsCmd.AddCommand(sopsRekeyCmd)
+
+	hostCmd.AddCommand(hostAddCmd)
+	hostCmd.AddCommand(hostRemoveCmd)
+
 	rootCmd.AddCommand(syncCmd)
 	rootCmd.AddCommand(restoreCmd)
 	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(generateCmd)
+	rootCmd.AddCommand(hostCmd)
+	rootCmd.AddCommand(revokeCmd)
+	rootCmd.AddCommand(exportPublicCmd)
+	rootCmd.AddCommand(publishCmd)
+	rootCmd.AddCommand(gitCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(sshCmd)
+	rootCmd.AddCommand(nixCmd)
+	rootCmd.AddCommand(sopsCmd)
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
+		var valErr *config.ValidationError
+		if errors.As(err, &valErr) {
+			fmt.Fprintln(os.Stderr, valErr.Error())
+			os.Exit(2)
+		}
 		var cfgErr *config.ConfigError
 		if errors.As(err, &cfgErr) {
 			fmt.Fprintln(os.Stderr, cfgErr.Error())
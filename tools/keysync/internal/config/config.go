@@ -1,8 +1,10 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -12,36 +14,176 @@ import (
 // ConfigError represents a configuration or usage error.
 type ConfigError struct {
 	Msg string
+	// Path is the config field the error is about, for example
+	// keys.identity.title.
+	Path string
+	// Pos is where the field is defined, when known.
+	Pos Position
+	// Warning marks problems reported by lint that do not fail validation.
+	Warning bool
 }
 
-// Error returns the configuration error message.
+// Error returns the configuration error message, prefixed by its source
+// position when known.
 func (e *ConfigError) Error() string {
-	return e.Msg
+	msg := e.Msg
+	if e.Warning {
+		msg = "warning: " + msg
+	}
+	if pos := e.Pos.String(); pos != "" {
+		return pos + ": " + msg
+	}
+	return msg
+}
+
+// fieldError returns a ConfigError about the config field at path.
+func fieldError(path, format string, args ...any) *ConfigError {
+	return &ConfigError{Path: path, Msg: path + " " + fmt.Sprintf(format, args...)}
 }
 
 // Config is the top-level keysync configuration.
 type Config struct {
-	Version int              `yaml:"version"`
-	Vault   string           `yaml:"vault"`
-	Keys    map[string]*Key  `yaml:"keys"`
-	Hosts   map[string]*Host `yaml:"hosts"`
+	Version int    `yaml:"version"`
+	Vault   string `yaml:"vault"`
+	// Account is the 1Password account of the vault, for example
+	// my.1password.com. When empty, the default signed-in account is used.
+	Account string `yaml:"account,omitempty"`
+	// Include lists further config files or globs, relative to the including
+	// file, that are merged into this one.
+	Include []string `yaml:"include,omitempty"`
+	// Keyserver is the HKP keyserver keysync publish uploads to, for example
+	// hkps://keys.openpgp.org.
+	Keyserver string          `yaml:"keyserver,omitempty"`
+	Keys      map[string]*Key `yaml:"keys"`
+	// Roles are named lists of key references that hosts compose (version
+	// 2). A "self" key name stands for the key named after the host.
+	Roles map[string][]string `yaml:"roles,omitempty"`
+	// Groups give several hosts the same roles (version 2).
+	Groups map[string]*Group `yaml:"groups,omitempty"`
+	Hosts  map[string]*Host  `yaml:"hosts"`
+	Sops   *Sops             `yaml:"sops,omitempty"`
+
+	// sources maps config paths such as keys.identity.title to where they
+	// are defined.
+	sources map[string]Position
+	// raw maps config paths to the text of values that were expanded,
+	// rendered or overridden when loading.
+	raw map[string]rawValue
+	// unknown lists the paths of fields the file sets that no config type
+	// defines.
+	unknown []string
+	// generated marks a config loaded by LoadData, which has no file to
+	// save to.
+	generated bool
+}
+
+// Group assigns roles to a set of hosts.
+type Group struct {
+	Hosts []string `yaml:"hosts"`
+	Roles []string `yaml:"roles,omitempty"`
+}
+
+// Sops defines the .sops.yaml creation rules rendered from the config.
+type Sops struct {
+	Rules []*SopsRule `yaml:"rules,omitempty"`
+}
+
+// SopsRule maps secret paths to the hosts and keys that can decrypt them.
+// A host contributes the primary fingerprints of the keys only it uses; "*"
+// stands for every host.
+type SopsRule struct {
+	PathRegex string   `yaml:"path_regex"`
+	Hosts     []string `yaml:"hosts,omitempty"`
+	Keys      []string `yaml:"keys,omitempty"`
 }
 
 // Key defines one named key that can be synced.
 type Key struct {
 	Title       string             `yaml:"title"`
-	Fingerprint string             `yaml:"fingerprint"`
+	Fingerprint string             `yaml:"fingerprint,omitempty"`
 	Subkeys     map[string]*Subkey `yaml:"subkeys,omitempty"`
+	Spec        *KeySpec           `yaml:"spec,omitempty"`
+	// Trust is the owner-trust applied on restore. When empty, the level
+	// recorded at backup time is used.
+	Trust string `yaml:"trust,omitempty"`
+	// Vault and Account override where the key's items are stored.
+	Vault   string `yaml:"vault,omitempty"`
+	Account string `yaml:"account,omitempty"`
+
+	// titleTemplate is the title as written when it is a template.
+	titleTemplate string
+}
+
+// KeySpec describes how keysync generate creates a key and its subkeys.
+type KeySpec struct {
+	UID       string                 `yaml:"uid"`
+	Algorithm string                 `yaml:"algorithm,omitempty"`
+	Expires   string                 `yaml:"expires,omitempty"`
+	Subkeys   map[string]*SubkeySpec `yaml:"subkeys"`
+}
+
+// SubkeySpec describes one subkey created by keysync generate.
+type SubkeySpec struct {
+	Algorithm    string `yaml:"algorithm,omitempty"`
+	Capabilities string `yaml:"capabilities"`
+	Expires      string `yaml:"expires,omitempty"`
 }
 
 // Subkey defines a named subkey under a top-level key.
 type Subkey struct {
 	Fingerprint string `yaml:"fingerprint"`
+	// Vault and Account override where the subkey's items are stored.
+	Vault   string `yaml:"vault,omitempty"`
+	Account string `yaml:"account,omitempty"`
 }
 
 // Host defines key references for a specific machine.
 type Host struct {
-	Keys []string `yaml:"keys"`
+	// Roles name entries of the top-level roles (version 2).
+	Roles []string `yaml:"roles,omitempty"`
+	Keys  []string `yaml:"keys,omitempty"`
+	// PassphrasePolicy controls how synced secret subkeys are protected:
+	// inherit keeps the primary keyring's passphrase, generate re-protects them
+	// with a per-host passphrase, and none stores them unprotected.
+	PassphrasePolicy string `yaml:"passphrase_policy,omitempty"`
+	// PublicKeys names top-level keys whose public part the host imports, for
+	// example to encrypt to other hosts. Their secrets are never restored.
+	PublicKeys []string `yaml:"public_keys,omitempty"`
+	// Vault and Account set where the items the host references are stored,
+	// unless their key or subkey sets its own.
+	Vault   string `yaml:"vault,omitempty"`
+	Account string `yaml:"account,omitempty"`
+
+	// refs holds the references expanded from roles, groups and keys by
+	// Validate.
+	refs []string
+}
+
+// Passphrase policies for host subkey items.
+const (
+	PassphraseInherit  = "inherit"
+	PassphraseGenerate = "generate"
+	PassphraseNone     = "none"
+)
+
+// TrustLevels lists the accepted owner-trust names from lowest to highest.
+var TrustLevels = []string{"unknown", "never", "marginal", "full", "ultimate"}
+
+// Refs returns the host's key references: those expanded from its roles,
+// groups and keys by Validate, or its keys before validation.
+func (h *Host) Refs() []string {
+	if h.refs != nil {
+		return h.refs
+	}
+	return h.Keys
+}
+
+// Policy returns the host's passphrase policy, defaulting to inherit.
+func (h *Host) Policy() string {
+	if h.PassphrasePolicy == "" {
+		return PassphraseInherit
+	}
+	return h.PassphrasePolicy
 }
 
 // ResolvedRef is the result of resolving a dot-notation reference.
@@ -51,102 +193,223 @@ type ResolvedRef struct {
 	Fingerprint string
 	ParentFP    string
 	ItemTitle   string
+	Location    Location
+	// Host is set when the item belongs to a single host because of its
+	// passphrase policy.
+	Host string
+}
+
+// Pending reports whether the key is declared by a spec but not generated yet.
+func (k *Key) Pending() bool {
+	return k.Spec != nil && strings.TrimSpace(k.Fingerprint) == ""
+}
+
+// SubkeyNames returns the key's subkey names in sorted order.
+func (k *Key) SubkeyNames() []string {
+	names := make([]string, 0, len(k.Subkeys))
+	for name := range k.Subkeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-// Load reads and validates a keysync configuration file.
+// Load reads and validates a keysync configuration file and the files it
+// includes.
 func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, &ConfigError{Msg: fmt.Sprintf("cannot read config file: %v", err)}
+	return LoadFiles(path, nil)
+}
+
+// LoadFiles loads a configuration file like Load, reading it and the files it
+// includes from files.
+func LoadFiles(path string, files Files) (*Config, error) {
+	l := &loader{seen: make(map[string]string), files: files}
+	if err := l.load(path, Position{}); err != nil {
+		return nil, err
+	}
+
+	if err := l.cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l.cfg.normalize()
+
+	return l.cfg, nil
+}
+
+// Lint loads a configuration file and its includes without validating them
+// and returns every error and warning found, sorted by source position.
+func Lint(path string) ([]*ConfigError, error) {
+	return LintData(path, nil)
+}
+
+// LintData is Lint for a configuration given as data instead of a file, as
+// LoadData takes it. A nil data reads the file at name.
+func LintData(name string, data []byte) ([]*ConfigError, error) {
+	l := &loader{seen: make(map[string]string), root: data}
+	if err := l.load(name, Position{}); err != nil {
+		return nil, err
 	}
+	return l.cfg.check(), nil
+}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, &ConfigError{Msg: fmt.Sprintf("invalid YAML: %v", err)}
+// Parse decodes and validates keysync configuration YAML. Includes are not
+// supported, as there is no file to resolve them against.
+func Parse(data []byte) (*Config, error) {
+	cfg, err := decode(data, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Include) > 0 {
+		return nil, &ConfigError{Path: "include", Pos: cfg.sources["include"], Msg: "include is only supported when loading a config file"}
 	}
 
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
+	cfg.normalize()
 
-	return &cfg, nil
+	return cfg, nil
 }
 
-// Validate checks the configuration for required fields and valid references.
-func (c *Config) Validate() error {
-	if c.Version != 1 {
-		return &ConfigError{Msg: fmt.Sprintf("unsupported config version: %d (expected 1)", c.Version)}
+// Marshal encodes the configuration as YAML.
+func (c *Config) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
 	}
+	return buf.Bytes(), nil
+}
 
-	if strings.TrimSpace(c.Vault) == "" {
-		return &ConfigError{Msg: "vault is required"}
+// Save writes the configuration to path, replacing the file atomically. An
+// existing file is updated in place, so unchanged entries keep their comments
+// and order. Configs that include other files cannot be saved, as the merged result
+// would inline them.
+func (c *Config) Save(path string) error {
+	if err := c.CanSave(path); err != nil {
+		return err
+	}
+	doc, err := OpenDocument(path)
+	if err != nil {
+		return err
+	}
+	if err := doc.Update(c); err != nil {
+		return err
 	}
+	return doc.Save()
+}
 
-	if len(c.Keys) == 0 {
-		return &ConfigError{Msg: "keys must not be empty"}
+// CanSave returns the error Save would refuse to write the config to path
+// with, so that commands can check it before changing anything else.
+func (c *Config) CanSave(path string) error {
+	if c.generated {
+		return &ConfigError{Msg: "cannot save a config generated from another source, such as Nix; change it there"}
 	}
+	if len(c.Include) > 0 {
+		return includeError(path)
+	}
+	return CanWrite(path)
+}
 
-	for name, key := range c.Keys {
-		if key == nil {
-			return &ConfigError{Msg: fmt.Sprintf("keys.%s is null", name)}
-		}
-		if strings.TrimSpace(key.Title) == "" {
-			return &ConfigError{Msg: fmt.Sprintf("keys.%s.title is required", name)}
-		}
-		keyFP := strings.TrimSpace(key.Fingerprint)
-		if keyFP == "" {
-			return &ConfigError{Msg: fmt.Sprintf("keys.%s.fingerprint is required", name)}
-		}
-		if len(keyFP) != 40 {
-			return &ConfigError{Msg: fmt.Sprintf("keys.%s.fingerprint must be 40 characters", name)}
-		}
-		if len(key.Subkeys) == 0 {
-			return &ConfigError{Msg: fmt.Sprintf("keys.%s.subkeys must not be empty", name)}
-		}
-		for subName, sub := range key.Subkeys {
-			if sub == nil {
-				return &ConfigError{Msg: fmt.Sprintf("keys.%s.subkeys.%s is null", name, subName)}
-			}
-			subFP := strings.TrimSpace(sub.Fingerprint)
-			if subFP == "" {
-				return &ConfigError{Msg: fmt.Sprintf("keys.%s.subkeys.%s.fingerprint is required", name, subName)}
-			}
-			if len(subFP) != 40 {
-				return &ConfigError{Msg: fmt.Sprintf("keys.%s.subkeys.%s.fingerprint must be 40 characters", name, subName)}
-			}
-		}
+// CanWrite reports an error when the config file at path uses include, which
+// a config written over it would drop.
+func CanWrite(path string) error {
+	doc, err := OpenDocument(path)
+	if err != nil {
+		return err
 	}
+	include, err := doc.Get("include")
+	if err != nil {
+		return err
+	}
+	if include != nil {
+		return includeError(path)
+	}
+	return nil
+}
 
-	if len(c.Hosts) == 0 {
-		return &ConfigError{Msg: "hosts must not be empty"}
+func includeError(path string) error {
+	return &ConfigError{Msg: fmt.Sprintf("cannot rewrite %s because it uses include; edit the files by hand", path)}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
 	}
 
-	for hostName, host := range c.Hosts {
-		if host == nil {
-			return &ConfigError{Msg: fmt.Sprintf("hosts.%s is null", hostName)}
-		}
-		if len(host.Keys) == 0 {
-			return &ConfigError{Msg: fmt.Sprintf("hosts.%s.keys must not be empty", hostName)}
-		}
-		for i, keyRef := range host.Keys {
-			if !strings.Contains(keyRef, ".") {
-				return &ConfigError{Msg: fmt.Sprintf("hosts.%s.keys[%d] must be dot notation key.subkey: %q", hostName, i, keyRef)}
-			}
-			if _, err := c.ResolveRef(keyRef); err != nil {
-				return &ConfigError{Msg: fmt.Sprintf("hosts.%s.keys[%d] has invalid reference %q: %v", hostName, i, keyRef, err)}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot write config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write config file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write config file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("cannot write config file: %w", err)
+	}
+	return nil
+}
+
+// SopsRecipients returns the primary fingerprints that a sops rule encrypts
+// to, in rule order without duplicates.
+func (c *Config) SopsRecipients(rule *SopsRule) ([]string, error) {
+	var keyNames []string
+	for _, hostName := range rule.Hosts {
+		if hostName == "*" {
+			for _, name := range c.AllHostNames() {
+				keyNames = append(keyNames, c.OwnedKeys(name)...)
 			}
+			continue
+		}
+		owned := c.OwnedKeys(hostName)
+		if len(owned) == 0 {
+			return nil, &ConfigError{Msg: fmt.Sprintf("host %q has no keys of its own to encrypt to", hostName)}
 		}
+		keyNames = append(keyNames, owned...)
 	}
+	keyNames = append(keyNames, rule.Keys...)
 
-	return nil
+	seen := make(map[string]struct{})
+	var fps []string
+	for _, keyName := range keyNames {
+		key, err := c.GetKey(keyName)
+		if err != nil {
+			return nil, err
+		}
+		if key.Pending() {
+			return nil, &ConfigError{Msg: fmt.Sprintf("key %q has not been generated yet", keyName)}
+		}
+		if _, ok := seen[key.Fingerprint]; ok {
+			continue
+		}
+		seen[key.Fingerprint] = struct{}{}
+		fps = append(fps, key.Fingerprint)
+	}
+	return fps, nil
 }
 
 // ResolveRef resolves a key.subkey reference to key metadata.
 func (c *Config) ResolveRef(ref string) (*ResolvedRef, error) {
-	parts := strings.Split(ref, ".")
-	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+	if !refPattern.MatchString(ref) {
 		return nil, &ConfigError{Msg: fmt.Sprintf("invalid key reference %q (expected key.subkey)", ref)}
 	}
+	parts := strings.Split(ref, ".")
 
 	keyName := parts[0]
 	subkeyName := parts[1]
@@ -156,20 +419,101 @@ func (c *Config) ResolveRef(ref string) (*ResolvedRef, error) {
 		return nil, err
 	}
 
-	sub, ok := key.Subkeys[subkeyName]
-	if !ok || sub == nil {
+	var subFP string
+	var subLoc Location
+	if sub, ok := key.Subkeys[subkeyName]; ok && sub != nil {
+		subFP = sub.Fingerprint
+		subLoc = Location{Vault: sub.Vault, Account: sub.Account}
+	} else if key.Spec == nil || key.Spec.Subkeys[subkeyName] == nil {
 		return nil, &ConfigError{Msg: fmt.Sprintf("subkey %q not found under key %q", subkeyName, keyName)}
 	}
 
+	loc := c.location(subLoc, Location{Vault: key.Vault, Account: key.Account})
 	return &ResolvedRef{
 		KeyName:     keyName,
 		SubkeyName:  subkeyName,
-		Fingerprint: sub.Fingerprint,
+		Fingerprint: subFP,
 		ParentFP:    key.Fingerprint,
-		ItemTitle:   key.Title + "/" + subkeyName,
+		ItemTitle:   key.subkeyTitle(keyName, subkeyName, loc.Vault),
+		Location:    loc,
 	}, nil
 }
 
+// ResolveHostRef resolves a reference as used by one host. Hosts that do not
+// inherit the primary keyring's passphrase get their own item per reference,
+// titled "<item title>@<host>".
+func (c *Config) ResolveHostRef(hostName, ref string) (*ResolvedRef, error) {
+	host, err := c.GetHost(hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := c.ResolveRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	key := c.Keys[resolved.KeyName]
+	var subLoc Location
+	if sub := key.Subkeys[resolved.SubkeyName]; sub != nil {
+		subLoc = Location{Vault: sub.Vault, Account: sub.Account}
+	}
+	resolved.Location = c.location(subLoc, Location{Vault: key.Vault, Account: key.Account}, Location{Vault: host.Vault, Account: host.Account})
+	resolved.ItemTitle = key.subkeyTitle(resolved.KeyName, resolved.SubkeyName, resolved.Location.Vault)
+
+	if host.Policy() != PassphraseInherit {
+		resolved.Host = hostName
+		resolved.ItemTitle += "@" + hostName
+	}
+	return resolved, nil
+}
+
+// Location is where 1Password items are stored. An empty Account uses the
+// default signed-in account.
+type Location struct {
+	Vault   string
+	Account string
+}
+
+// KeyLocation returns where a key's backup item is stored.
+func (c *Config) KeyLocation(keyName string) Location {
+	key := c.Keys[keyName]
+	if key == nil {
+		return c.location()
+	}
+	return c.location(Location{Vault: key.Vault, Account: key.Account})
+}
+
+// location picks the vault and, separately, the account from the most
+// specific level that sets them, falling back to the top-level ones.
+func (c *Config) location(levels ...Location) Location {
+	loc := Location{Vault: c.Vault, Account: c.Account}
+	for i := len(levels) - 1; i >= 0; i-- {
+		if levels[i].Vault != "" {
+			loc.Vault = levels[i].Vault
+		}
+		if levels[i].Account != "" {
+			loc.Account = levels[i].Account
+		}
+	}
+	return loc
+}
+
+// Accounts returns the distinct accounts of the given locations in sorted
+// order, with "" standing for the default account.
+func Accounts(locs ...Location) []string {
+	seen := make(map[string]struct{})
+	var accounts []string
+	for _, loc := range locs {
+		if _, ok := seen[loc.Account]; !ok {
+			seen[loc.Account] = struct{}{}
+			accounts = append(accounts, loc.Account)
+		}
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
 // GetKey returns a key by name.
 func (c *Config) GetKey(name string) (*Key, error) {
 	key, ok := c.Keys[name]
@@ -197,3 +541,88 @@ func (c *Config) AllKeyNames() []string {
 	sort.Strings(names)
 	return names
 }
+
+// AllHostNames returns all configured host names in sorted order.
+func (c *Config) AllHostNames() []string {
+	names := make([]string, 0, len(c.Hosts))
+	for name := range c.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OwnedKeys returns the names of keys referenced by the host and by no other
+// host, in sorted order.
+func (c *Config) OwnedKeys(hostName string) []string {
+	host, ok := c.Hosts[hostName]
+	if !ok {
+		return nil
+	}
+
+	shared := make(map[string]struct{})
+	for name, other := range c.Hosts {
+		if name == hostName {
+			continue
+		}
+		for _, ref := range other.Refs() {
+			shared[refKeyName(ref)] = struct{}{}
+		}
+	}
+
+	seen := make(map[string]struct{})
+	var owned []string
+	for _, ref := range host.Refs() {
+		keyName := refKeyName(ref)
+		if _, ok := shared[keyName]; ok {
+			continue
+		}
+		if _, ok := seen[keyName]; ok {
+			continue
+		}
+		seen[keyName] = struct{}{}
+		owned = append(owned, keyName)
+	}
+	sort.Strings(owned)
+	return owned
+}
+
+// PeerKeys returns the names of keys whose public part the host imports: its
+// public_keys and, when withPeers is set, the keys owned by every other host.
+// Keys the host already references are left out. The result is sorted.
+func (c *Config) PeerKeys(hostName string, withPeers bool) []string {
+	host, ok := c.Hosts[hostName]
+	if !ok {
+		return nil
+	}
+
+	skip := make(map[string]struct{})
+	for _, ref := range host.Refs() {
+		skip[refKeyName(ref)] = struct{}{}
+	}
+
+	candidates := append([]string{}, host.PublicKeys...)
+	if withPeers {
+		for _, name := range c.AllHostNames() {
+			if name != hostName {
+				candidates = append(candidates, c.OwnedKeys(name)...)
+			}
+		}
+	}
+
+	var peers []string
+	for _, keyName := range candidates {
+		if _, ok := skip[keyName]; ok {
+			continue
+		}
+		skip[keyName] = struct{}{}
+		peers = append(peers, keyName)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+func refKeyName(ref string) string {
+	keyName, _, _ := strings.Cut(ref, ".")
+	return keyName
+}
@@ -0,0 +1,566 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document is a config file held as a YAML node tree, so that edits keep the
+// file's comments, key order and quoting. Paths address fields the way
+// validation errors do, for example hosts.faron.keys or sops.rules[0].hosts.
+//
+// A document is the root file only: entries of included files are not part
+// of it, and defining one again in the root fails validation on Save.
+type Document struct {
+	path   string
+	node   yaml.Node
+	indent int
+	// blank marks nodes preceded by a blank line in the file, which yaml.v3
+	// drops when encoding.
+	blank map[*yaml.Node]bool
+}
+
+// OpenDocument reads a config file for editing. A missing file gives an empty
+// document that Save creates.
+func OpenDocument(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Document{path: path, indent: 2, blank: make(map[*yaml.Node]bool)}, nil
+	}
+	if err != nil {
+		return nil, &ConfigError{Msg: fmt.Sprintf("cannot read config file: %v", err)}
+	}
+	return parseDocument(path, data)
+}
+
+// parseDocument parses the contents of the config file at path.
+func parseDocument(path string, data []byte) (*Document, error) {
+	d := &Document{path: path, indent: 2, blank: make(map[*yaml.Node]bool)}
+	if err := yaml.Unmarshal(data, &d.node); err != nil {
+		return nil, &ConfigError{Pos: Position{File: path}, Msg: fmt.Sprintf("invalid YAML: %v", err)}
+	}
+	if root := d.root(); root.Kind != yaml.MappingNode {
+		return nil, &ConfigError{Pos: Position{File: path, Line: root.Line, Column: root.Column}, Msg: "config must be a mapping"}
+	}
+	d.indent = detectIndent(d.root())
+	markBlankLines(d.root(), strings.Split(string(data), "\n"), d.blank)
+	return d, nil
+}
+
+// root returns the top-level mapping, creating it for an empty document.
+func (d *Document) root() *yaml.Node {
+	if d.node.Kind == 0 {
+		d.node.Kind = yaml.DocumentNode
+	}
+	if len(d.node.Content) == 0 {
+		d.node.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	return d.node.Content[0]
+}
+
+// detectIndent returns the indentation of the first nested block mapping, so
+// that re-encoding does not reindent the whole file.
+func detectIndent(node *yaml.Node) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind == yaml.MappingNode && value.Style&yaml.FlowStyle == 0 && len(value.Content) > 0 {
+			if indent := value.Content[0].Column - key.Column; indent > 0 {
+				return indent
+			}
+		}
+	}
+	return 2
+}
+
+// ParseValue parses a command-line value as YAML, so that numbers, lists and
+// mappings can be given as well as strings. Lists and mappings are written in
+// block style.
+func ParseValue(value string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, &ConfigError{Msg: fmt.Sprintf("invalid value %q: %v", value, err)}
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}, nil
+	}
+	node := doc.Content[0]
+	blockStyle(node)
+	return node, nil
+}
+
+func blockStyle(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode {
+		node.Style &^= yaml.FlowStyle
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// Get returns the node at path, or nil when it does not exist.
+func (d *Document) Get(path string) (*yaml.Node, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	node := d.root()
+	for _, seg := range segments {
+		if node = seg.child(node); node == nil {
+			return nil, nil
+		}
+	}
+	return node, nil
+}
+
+// Set replaces the value at path, creating missing mappings on the way. It
+// reports whether the document changed.
+func (d *Document) Set(path string, value *yaml.Node) (bool, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return false, err
+	}
+	parent, err := d.walk(path, segments[:len(segments)-1], true)
+	if err != nil {
+		return false, err
+	}
+
+	last := segments[len(segments)-1]
+	if existing := last.child(parent); existing != nil {
+		before := cloneNode(existing)
+		mergeNode(existing, value)
+		return !sameNode(before, existing), nil
+	}
+	if last.isIndex {
+		return false, &ConfigError{Path: path, Msg: fmt.Sprintf("%s does not exist", path)}
+	}
+	if parent.Kind != yaml.MappingNode {
+		return false, &ConfigError{Path: path, Msg: fmt.Sprintf("%s is not inside a mapping", path)}
+	}
+	parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last.key}, value)
+	return true, nil
+}
+
+// Add appends values to the list at path, creating it when missing. Values
+// already in the list are skipped; the number added is returned.
+func (d *Document) Add(path string, values ...*yaml.Node) (int, error) {
+	seq, err := d.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	if seq == nil {
+		seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if _, err := d.Set(path, seq); err != nil {
+			return 0, err
+		}
+		if seq, err = d.Get(path); err != nil {
+			return 0, err
+		}
+	}
+	if seq.Kind != yaml.SequenceNode {
+		return 0, &ConfigError{Path: path, Msg: fmt.Sprintf("%s is not a list", path)}
+	}
+
+	added := 0
+	for _, value := range values {
+		if indexOf(seq.Content, value, nil) >= 0 {
+			continue
+		}
+		seq.Content = append(seq.Content, value)
+		added++
+	}
+	return added, nil
+}
+
+// Remove deletes the entry at path. It reports whether the entry existed.
+func (d *Document) Remove(path string) (bool, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return false, err
+	}
+	parent, err := d.walk(path, segments[:len(segments)-1], false)
+	if err != nil || parent == nil {
+		return false, err
+	}
+
+	last := segments[len(segments)-1]
+	switch {
+	case last.isIndex && parent.Kind == yaml.SequenceNode:
+		if last.index >= len(parent.Content) {
+			return false, nil
+		}
+		parent.Content = append(parent.Content[:last.index], parent.Content[last.index+1:]...)
+		return true, nil
+	case !last.isIndex && parent.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == last.key {
+				parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// RemoveValues deletes values from the list at path and returns how many were
+// removed.
+func (d *Document) RemoveValues(path string, values ...*yaml.Node) (int, error) {
+	seq, err := d.Get(path)
+	if err != nil || seq == nil {
+		return 0, err
+	}
+	if seq.Kind != yaml.SequenceNode {
+		return 0, &ConfigError{Path: path, Msg: fmt.Sprintf("%s is not a list", path)}
+	}
+
+	removed := 0
+	for _, value := range values {
+		if i := indexOf(seq.Content, value, nil); i >= 0 {
+			seq.Content = append(seq.Content[:i], seq.Content[i+1:]...)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Update rewrites the document to hold cfg. Entries that did not change keep
+// their comments and formatting, and new entries are appended to their
+// mapping.
+func (d *Document) Update(cfg *Config) error {
+	var node yaml.Node
+	if err := node.Encode(cfg); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	cfg.restoreRaw(&node, "")
+	mergeNode(d.root(), &node)
+	return nil
+}
+
+// Bytes encodes the document.
+func (d *Document) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(d.indent)
+	if err := enc.Encode(&d.node); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	if len(d.blank) == 0 {
+		return buf.Bytes(), nil
+	}
+
+	// Find where the marked nodes ended up and put their blank lines back.
+	var out yaml.Node
+	if err := yaml.Unmarshal(buf.Bytes(), &out); err != nil || len(out.Content) == 0 {
+		return buf.Bytes(), nil
+	}
+	before := make(map[int]bool)
+	matchBlankLines(d.root(), out.Content[0], d.blank, before)
+
+	var result bytes.Buffer
+	for i, line := range strings.SplitAfter(buf.String(), "\n") {
+		if before[i+1] {
+			result.WriteByte('\n')
+		}
+		result.WriteString(line)
+	}
+	return result.Bytes(), nil
+}
+
+// blockChildren returns the mapping keys or sequence items of a node, which
+// are the nodes that start a line.
+func blockChildren(node *yaml.Node) []*yaml.Node {
+	switch node.Kind {
+	case yaml.MappingNode:
+		keys := make([]*yaml.Node, 0, len(node.Content)/2)
+		for i := 0; i < len(node.Content); i += 2 {
+			keys = append(keys, node.Content[i])
+		}
+		return keys
+	case yaml.SequenceNode:
+		return node.Content
+	}
+	return nil
+}
+
+// firstLine returns the line a node's head comment or, without one, the node
+// itself starts on.
+func firstLine(node *yaml.Node) int {
+	if node.HeadComment == "" {
+		return node.Line
+	}
+	return node.Line - strings.Count(node.HeadComment, "\n") - 1
+}
+
+func markBlankLines(node *yaml.Node, lines []string, blank map[*yaml.Node]bool) {
+	if node.Style&yaml.FlowStyle != 0 {
+		return
+	}
+	for _, child := range blockChildren(node) {
+		if top := firstLine(child); top >= 2 && top-2 < len(lines) && strings.TrimSpace(lines[top-2]) == "" {
+			blank[child] = true
+		}
+	}
+	for _, child := range node.Content {
+		markBlankLines(child, lines, blank)
+	}
+}
+
+// matchBlankLines walks a document and its re-parsed encoding side by side and
+// records the encoded lines that need a blank line before them.
+func matchBlankLines(node, encoded *yaml.Node, blank map[*yaml.Node]bool, before map[int]bool) {
+	if node.Kind != encoded.Kind || len(node.Content) != len(encoded.Content) {
+		return
+	}
+	if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+		step := 1
+		if node.Kind == yaml.MappingNode {
+			step = 2
+		}
+		// A first entry follows its parent's key, where a blank line
+		// would be out of place once earlier entries are removed.
+		for i := step; i < len(node.Content); i += step {
+			if blank[node.Content[i]] {
+				before[firstLine(encoded.Content[i])] = true
+			}
+		}
+	}
+	for i := range node.Content {
+		matchBlankLines(node.Content[i], encoded.Content[i], blank, before)
+	}
+}
+
+// Config loads the edited document together with its includes and validates
+// the result.
+func (d *Document) Config() (*Config, error) {
+	data, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &loader{seen: make(map[string]string), root: data}
+	if err := l.load(d.path, Position{}); err != nil {
+		return nil, err
+	}
+	if err := l.cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l.cfg.normalize()
+	return l.cfg, nil
+}
+
+// Save validates the edited document and writes it back, replacing the file
+// atomically. Nothing is written when validation fails.
+func (d *Document) Save() error {
+	if _, err := d.Config(); err != nil {
+		return err
+	}
+	data, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.path, data)
+}
+
+// walk returns the node at segments, creating missing mappings when create is
+// set and returning nil otherwise.
+func (d *Document) walk(path string, segments []pathSegment, create bool) (*yaml.Node, error) {
+	node := d.root()
+	for i, seg := range segments {
+		child := seg.child(node)
+		if child == nil {
+			if !create || seg.isIndex || node.Kind != yaml.MappingNode {
+				if create {
+					return nil, &ConfigError{Path: path, Msg: fmt.Sprintf("%s does not exist", joinPath(segments[:i+1]))}
+				}
+				return nil, nil
+			}
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: seg.key}, child)
+		}
+		if child.Kind != yaml.MappingNode && child.Kind != yaml.SequenceNode {
+			return nil, &ConfigError{Path: path, Msg: fmt.Sprintf("%s is a value, not a mapping or list", joinPath(segments[:i+1]))}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// pathSegment is one step of a path: a mapping key or a list index.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func (s pathSegment) child(node *yaml.Node) *yaml.Node {
+	switch {
+	case s.isIndex && node.Kind == yaml.SequenceNode:
+		if s.index < len(node.Content) {
+			return node.Content[s.index]
+		}
+	case !s.isIndex && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == s.key {
+				return node.Content[i+1]
+			}
+		}
+	}
+	return nil
+}
+
+// parsePath splits a path such as sops.rules[0].hosts into segments.
+func parsePath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+	invalid := &ConfigError{Path: path, Msg: fmt.Sprintf("invalid path %q", path)}
+	for _, part := range strings.Split(path, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key == "" && rest == "" {
+			return nil, invalid
+		}
+		if key != "" {
+			segments = append(segments, pathSegment{key: key})
+		}
+		for rest != "" {
+			num, after, ok := strings.Cut(rest, "]")
+			index, err := strconv.Atoi(num)
+			if !ok || err != nil || index < 0 {
+				return nil, invalid
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+			if after == "" {
+				break
+			}
+			if !strings.HasPrefix(after, "[") {
+				return nil, invalid
+			}
+			rest = after[1:]
+		}
+	}
+	if len(segments) == 0 || segments[0].isIndex {
+		return nil, invalid
+	}
+	return segments, nil
+}
+
+func joinPath(segments []pathSegment) string {
+	var b strings.Builder
+	for _, seg := range segments {
+		if seg.isIndex {
+			fmt.Fprintf(&b, "[%d]", seg.index)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(seg.key)
+	}
+	return b.String()
+}
+
+// mergeNode makes dst hold the content of src while keeping dst's comments,
+// the order of its surviving mapping entries and, where the value allows it,
+// its style.
+func mergeNode(dst, src *yaml.Node) {
+	if dst.Kind != src.Kind || (dst.Kind == yaml.ScalarNode && dst.Tag != src.Tag && src.Tag != "") {
+		head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+		*dst = *src
+		dst.HeadComment, dst.LineComment, dst.FootComment = head, line, foot
+		return
+	}
+
+	switch dst.Kind {
+	case yaml.ScalarNode:
+		if dst.Value != src.Value {
+			dst.Value = src.Value
+			if dst.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 && !strings.Contains(src.Value, "\n") {
+				dst.Style = src.Style
+			}
+		}
+	case yaml.MappingNode:
+		content := dst.Content[:0:0]
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			if value := (pathSegment{key: dst.Content[i].Value}).child(src); value != nil {
+				mergeNode(dst.Content[i+1], value)
+				content = append(content, dst.Content[i], dst.Content[i+1])
+			}
+		}
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			if (pathSegment{key: src.Content[i].Value}).child(dst) == nil {
+				content = append(content, src.Content[i], src.Content[i+1])
+			}
+		}
+		dst.Content = content
+	case yaml.SequenceNode:
+		// Items equal to an existing item keep that item's node; others
+		// are merged into the item at the same index, if it is unused.
+		used := make([]bool, len(dst.Content))
+		content := make([]*yaml.Node, len(src.Content))
+		for i, item := range src.Content {
+			if j := indexOf(dst.Content, item, used); j >= 0 {
+				used[j] = true
+				content[i] = dst.Content[j]
+			}
+		}
+		for i, item := range src.Content {
+			if content[i] != nil {
+				continue
+			}
+			if i < len(dst.Content) && !used[i] && indexOf(src.Content, dst.Content[i], nil) < 0 {
+				used[i] = true
+				mergeNode(dst.Content[i], item)
+				content[i] = dst.Content[i]
+			} else {
+				content[i] = item
+			}
+		}
+		dst.Content = content
+	}
+}
+
+// indexOf returns the index of the first node in nodes with the same content
+// as node, skipping used ones, or -1.
+func indexOf(nodes []*yaml.Node, node *yaml.Node, used []bool) int {
+	for i, candidate := range nodes {
+		if (used == nil || !used[i]) && sameNode(candidate, node) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameNode reports whether two nodes hold the same content, ignoring
+// comments, styles and positions.
+func sameNode(a, b *yaml.Node) bool {
+	if a.Kind == yaml.AliasNode || b.Kind == yaml.AliasNode {
+		return a == b
+	}
+	if a.Kind != b.Kind || len(a.Content) != len(b.Content) {
+		return false
+	}
+	if a.Kind == yaml.ScalarNode {
+		return a.Value == b.Value && a.ShortTag() == b.ShortTag()
+	}
+	for i := range a.Content {
+		if !sameNode(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneNode(node *yaml.Node) *yaml.Node {
+	clone := *node
+	clone.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		clone.Content[i] = cloneNode(child)
+	}
+	return &clone
+}
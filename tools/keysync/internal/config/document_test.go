@@ -0,0 +1,122 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const documentBase = `# keysync configuration
+version: 2
+vault: Personal # the default vault
+
+keys:
+  # the main identity
+  identity:
+    title: keysync/gpg/identity
+    fingerprint: 57F09591B972D7CD2D8EAC22515584B240B685E5
+    subkeys:
+      signing:
+        fingerprint: 1EC935423DEED8090E00DEF9DD7ABAD676F208CB
+      encryption:
+        fingerprint: 3C0E4E0F2A8E5C1B8F7D6A5B4C3D2E1F0A9B8C7D
+
+hosts:
+  hylia:
+    keys:
+      - identity.signing # signs commits
+
+  lanayru:
+    keys:
+      - identity.signing
+`
+
+func TestDocumentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(t *testing.T, d *Document)
+		want string
+	}{
+		{
+			name: "unchanged",
+			edit: func(t *testing.T, d *Document) {},
+			want: documentBase,
+		},
+		{
+			name: "set scalar",
+			edit: func(t *testing.T, d *Document) {
+				if _, err := d.Set("vault", mustValue(t, "Work")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: strings.Replace(documentBase, "vault: Personal", "vault: Work", 1),
+		},
+		{
+			name: "add to list",
+			edit: func(t *testing.T, d *Document) {
+				if _, err := d.Add("hosts.hylia.keys", mustValue(t, "identity.encryption")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: strings.Replace(documentBase, "      - identity.signing # signs commits\n", "      - identity.signing # signs commits\n      - identity.encryption\n", 1),
+		},
+		{
+			name: "set new field",
+			edit: func(t *testing.T, d *Document) {
+				if _, err := d.Set("hosts.lanayru.passphrase_policy", mustValue(t, "generate")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: documentBase + "    passphrase_policy: generate\n",
+		},
+		{
+			name: "remove entry",
+			edit: func(t *testing.T, d *Document) {
+				if _, err := d.Remove("hosts.lanayru"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: strings.TrimSuffix(documentBase, "\n  lanayru:\n    keys:\n      - identity.signing\n"),
+		},
+		{
+			name: "update from config",
+			edit: func(t *testing.T, d *Document) {
+				cfg, err := d.Config()
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.Hosts["faron"] = &Host{Keys: []string{"identity.encryption"}}
+				if err := d.Update(cfg); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: documentBase + "  faron:\n    keys:\n      - identity.encryption\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parseDocument("keysync.yaml", []byte(documentBase))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(t, d)
+			got, err := d.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Bytes =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustValue(t *testing.T, value string) *yaml.Node {
+	t.Helper()
+	node, err := ParseValue(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rawValue is the text a config value was written as before ${ENV}
+// expansion, title rendering or a command-line override changed it, so that
+// saving the config writes the original text back.
+type rawValue struct {
+	value string
+	// effective is the value the config holds in its place.
+	effective string
+	// absent marks a value set by an override that the file does not have.
+	absent bool
+}
+
+var envPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${NAME} and ${NAME:-default} in the string values of a
+// decoded file; $$ stands for a literal $. Unset variables without a default
+// are an error.
+func expandEnv(node *yaml.Node, name, path string, raw map[string]rawValue) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			child := node.Content[i].Value
+			if path != "" {
+				child = path + "." + child
+			}
+			if err := expandEnv(node.Content[i+1], name, child, raw); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := expandEnv(item, name, fmt.Sprintf("%s[%d]", path, i), raw); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") || node.ShortTag() != "!!str" {
+			return nil
+		}
+		var unset string
+		value := envPattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+			if match == "$$" {
+				return "$"
+			}
+			m := envPattern.FindStringSubmatch(match)
+			if env, ok := os.LookupEnv(m[1]); ok {
+				return env
+			}
+			if m[2] == "" && unset == "" {
+				unset = m[1]
+			}
+			return m[3]
+		})
+		if unset != "" {
+			return &ConfigError{Path: path, Pos: Position{File: name, Line: node.Line, Column: node.Column}, Msg: fmt.Sprintf("%s references unset environment variable %s", path, unset)}
+		}
+		if value != node.Value {
+			raw[path] = rawValue{value: node.Value, effective: value}
+			node.Value = value
+			if node.Style == 0 {
+				// Let a plain value resolve again, so that ${VERSION}
+				// can give a number.
+				node.Tag = ""
+			}
+		}
+	}
+	return nil
+}
+
+// Override replaces one config value when loading, for example vault=Work
+// given as keysync --set.
+type Override struct {
+	Path  string
+	Value string
+}
+
+// ParseOverride parses a PATH=VALUE command-line override.
+func ParseOverride(s string) (Override, error) {
+	path, value, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(path) == "" {
+		return Override{}, &ConfigError{Msg: fmt.Sprintf("invalid override %q: expected PATH=VALUE", s)}
+	}
+	return Override{Path: strings.TrimSpace(path), Value: value}, nil
+}
+
+// LoadWith loads a configuration file like Load, after applying overrides to
+// the root file. Overrides only replace single values.
+func LoadWith(path string, overrides []Override) (*Config, error) {
+	if len(overrides) == 0 {
+		return Load(path)
+	}
+	return loadData(path, nil, overrides)
+}
+
+// LoadData loads a configuration from data instead of a file, for example
+// JSON evaluated from Nix, after applying overrides. name labels positions and
+// is what includes are resolved against. The result cannot be saved, as data
+// is generated from another source.
+func LoadData(name string, data []byte, overrides []Override) (*Config, error) {
+	cfg, err := loadData(name, data, overrides)
+	if err != nil {
+		return nil, err
+	}
+	cfg.generated = true
+	return cfg, nil
+}
+
+// loadData loads the config file at path, or data in its place when set,
+// after applying overrides.
+func loadData(path string, data []byte, overrides []Override) (*Config, error) {
+	raw := make(map[string]rawValue)
+	if len(overrides) > 0 {
+		var doc *Document
+		var err error
+		if data == nil {
+			doc, err = OpenDocument(path)
+		} else {
+			doc, err = parseDocument(path, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range overrides {
+			old, err := doc.Get(o.Path)
+			if err != nil {
+				return nil, err
+			}
+			value, err := ParseValue(o.Value)
+			if err != nil {
+				return nil, err
+			}
+			if value.Kind != yaml.ScalarNode || (old != nil && old.Kind != yaml.ScalarNode) {
+				return nil, &ConfigError{Path: o.Path, Msg: fmt.Sprintf("cannot override %s: overrides only replace single values", o.Path)}
+			}
+			if old == nil {
+				raw[o.Path] = rawValue{effective: value.Value, absent: true}
+			} else {
+				raw[o.Path] = rawValue{value: old.Value, effective: value.Value}
+			}
+			if _, err := doc.Set(o.Path, value); err != nil {
+				return nil, err
+			}
+		}
+		if data, err = doc.Bytes(); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &loader{seen: make(map[string]string), root: data}
+	if err := l.load(path, Position{}); err != nil {
+		return nil, err
+	}
+	for p, r := range raw {
+		// An override that references the environment was expanded after
+		// it was applied.
+		if expanded, ok := l.cfg.raw[p]; ok {
+			r.effective = expanded.effective
+		}
+		l.cfg.raw[p] = r
+	}
+
+	if err := l.cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l.cfg.normalize()
+	return l.cfg, nil
+}
+
+// TitleData is what an item title template can use, for example
+// "{{ .Vault }}/gpg/{{ .KeyName }}". SubkeyName is empty for the key's
+// backup item.
+type TitleData struct {
+	Vault      string
+	KeyName    string
+	SubkeyName string
+}
+
+// renderTitle executes a title template.
+func renderTitle(text string, data TitleData) (string, error) {
+	tmpl, err := template.New("title").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// renderTitles renders templated key titles with the key's vault, keeping
+// each template so that subkey item titles can be rendered from it with the
+// vault each reference resolves to.
+func (v *validator) renderTitles() {
+	c := v.c
+	for _, name := range sortedKeys(c.Keys) {
+		key := c.Keys[name]
+		if key == nil {
+			continue
+		}
+		if key.titleTemplate == "" && strings.Contains(key.Title, "{{") {
+			key.titleTemplate = key.Title
+		}
+		if key.titleTemplate == "" {
+			continue
+		}
+
+		path := "keys." + name + ".title"
+		title, err := renderTitle(key.titleTemplate, TitleData{Vault: c.KeyLocation(name).Vault, KeyName: name})
+		if err != nil {
+			v.errorf(path, "is not a valid template: %v", err)
+			continue
+		}
+		key.Title = title
+		if c.raw == nil {
+			c.raw = make(map[string]rawValue)
+		}
+		raw := c.raw[path]
+		if raw.value == "" {
+			raw.value = key.titleTemplate
+		}
+		raw.effective = title
+		c.raw[path] = raw
+	}
+}
+
+// subkeyTitle returns the item title of a subkey whose item is stored in
+// vault: the title template rendered with the subkey name when it uses
+// .SubkeyName, otherwise the title rendered for that vault followed by
+// /<subkey>.
+func (k *Key) subkeyTitle(keyName, subName, vault string) string {
+	if k.titleTemplate == "" {
+		return k.Title + "/" + subName
+	}
+	data := TitleData{Vault: vault, KeyName: keyName}
+	title, err := renderTitle(k.titleTemplate, data)
+	if err != nil {
+		return k.Title + "/" + subName
+	}
+	data.SubkeyName = subName
+	if subTitle, err := renderTitle(k.titleTemplate, data); err == nil && subTitle != title {
+		return subTitle
+	}
+	return title + "/" + subName
+}
+
+// restoreRaw puts the original text of expanded, rendered and overridden
+// values back into an encoded config.
+func (c *Config) restoreRaw(node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		content := node.Content[:0]
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			child := node.Content[i].Value
+			if path != "" {
+				child = path + "." + child
+			}
+			if raw, ok := c.raw[child]; ok && raw.absent && node.Content[i+1].Value == raw.effective {
+				continue
+			}
+			c.restoreRaw(node.Content[i+1], child)
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+		node.Content = content
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			c.restoreRaw(item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case yaml.ScalarNode:
+		if raw, ok := c.raw[path]; ok && !raw.absent && node.Value == raw.effective {
+			node.Value = raw.value
+			node.Tag = ""
+		}
+	}
+}
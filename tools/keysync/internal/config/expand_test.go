@@ -0,0 +1,137 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const expandBase = `version: 2
+vault: ${KEYSYNC_VAULT:-Personal}
+keys:
+  identity:
+    title: "{{ .Vault }}/gpg/{{ .KeyName }}"
+    fingerprint: 57F09591B972D7CD2D8EAC22515584B240B685E5
+    subkeys:
+      signing:
+        fingerprint: 1EC935423DEED8090E00DEF9DD7ABAD676F208CB
+      encryption:
+        fingerprint: 3C0E4E0F2A8E5C1B8F7D6A5B4C3D2E1F0A9B8C7D
+        vault: Keys
+hosts:
+  hylia:
+    keys:
+      - identity.signing
+      - identity.encryption
+  lanayru:
+    vault: Lanayru
+    passphrase_policy: generate
+    keys:
+      - identity.signing
+`
+
+func TestOverridePrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       string
+		overrides []Override
+		want      string
+	}{
+		{name: "default", want: "Personal"},
+		{name: "environment", env: "Work", want: "Work"},
+		{name: "override beats environment", env: "Work", overrides: []Override{{Path: "vault", Value: "Ops"}}, want: "Ops"},
+		{name: "override expands environment", env: "Work", overrides: []Override{{Path: "vault", Value: "${KEYSYNC_VAULT}-ops"}}, want: "Work-ops"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KEYSYNC_VAULT", tt.env)
+			if tt.env == "" {
+				os.Unsetenv("KEYSYNC_VAULT")
+			}
+			path := filepath.Join(writeFiles(t, map[string]string{"keysync.yaml": expandBase}), "keysync.yaml")
+
+			cfg, err := LoadWith(path, tt.overrides)
+			if err != nil {
+				t.Fatalf("LoadWith: %v", err)
+			}
+			if cfg.Vault != tt.want {
+				t.Errorf("vault = %q, want %q", cfg.Vault, tt.want)
+			}
+			if want := tt.want + "/gpg/identity"; cfg.Keys["identity"].Title != want {
+				t.Errorf("title = %q, want %q", cfg.Keys["identity"].Title, want)
+			}
+
+			// Saving writes the text of the file back, not the values the
+			// environment, the template or the override gave.
+			doc, err := OpenDocument(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.Update(cfg); err != nil {
+				t.Fatal(err)
+			}
+			data, err := doc.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != expandBase {
+				t.Errorf("saved config =\n%s\nwant\n%s", data, expandBase)
+			}
+		})
+	}
+}
+
+func TestOverrideRejectsLists(t *testing.T) {
+	path := filepath.Join(writeFiles(t, map[string]string{"keysync.yaml": expandBase}), "keysync.yaml")
+	_, err := LoadWith(path, []Override{{Path: "hosts.hylia.keys", Value: "identity.signing"}})
+	if err == nil || !strings.Contains(err.Error(), "overrides only replace single values") {
+		t.Errorf("LoadWith error = %v, want lists rejected", err)
+	}
+}
+
+func TestTitleTemplates(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		host  string
+		ref   string
+		want  string
+	}{
+		{name: "key vault", title: "{{ .Vault }}/gpg/{{ .KeyName }}", ref: "identity.signing", want: "Personal/gpg/identity/signing"},
+		{name: "subkey vault", title: "{{ .Vault }}/gpg/{{ .KeyName }}", ref: "identity.encryption", want: "Keys/gpg/identity/encryption"},
+		{name: "host inherits", title: "{{ .Vault }}/gpg/{{ .KeyName }}", host: "hylia", ref: "identity.signing", want: "Personal/gpg/identity/signing"},
+		{name: "host vault", title: "{{ .Vault }}/gpg/{{ .KeyName }}", host: "lanayru", ref: "identity.signing", want: "Lanayru/gpg/identity/signing@lanayru"},
+		{name: "subkey name", title: "{{ .Vault }}/{{ .KeyName }}-{{ .SubkeyName }}", ref: "identity.encryption", want: "Keys/identity-encryption"},
+		{name: "plain title", title: "keysync/gpg/identity", host: "lanayru", ref: "identity.signing", want: "keysync/gpg/identity/signing@lanayru"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KEYSYNC_VAULT", "")
+			os.Unsetenv("KEYSYNC_VAULT")
+			cfg, err := Parse([]byte(strings.Replace(expandBase, `"{{ .Vault }}/gpg/{{ .KeyName }}"`, `"`+tt.title+`"`, 1)))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			var resolved *ResolvedRef
+			if tt.host == "" {
+				resolved, err = cfg.ResolveRef(tt.ref)
+			} else {
+				resolved, err = cfg.ResolveHostRef(tt.host, tt.ref)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolved.ItemTitle != tt.want {
+				t.Errorf("ItemTitle = %q, want %q", resolved.ItemTitle, tt.want)
+			}
+		})
+	}
+}
+
+func TestTitleTemplateErrors(t *testing.T) {
+	_, err := Parse([]byte(strings.Replace(expandBase, "{{ .KeyName }}", "{{ .Name }}", 1)))
+	if err == nil || !strings.Contains(err.Error(), "5:5: keys.identity.title is not a valid template") {
+		t.Errorf("Parse error = %v, want the title's position", err)
+	}
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loader reads a config file and merges the files it includes into it.
+//
+// Merge rules: keys, roles, groups and hosts entries must be defined in
+// exactly one file; sops rules are appended in include order, after the rules
+// of the including file; every other top-level field, such as version, vault
+// or account, may only be set in the root file. Globs are expanded in sorted
+// order.
+type loader struct {
+	cfg *Config
+	// seen maps the absolute path of every loaded file to its display name.
+	seen map[string]string
+	// root, when set, replaces the contents of the root file, so that an
+	// edited document can be validated before it is written.
+	root []byte
+	// files reads the files and expands the globs; nil uses the file system.
+	files Files
+}
+
+// Files reads config files and expands include globs, for loading a config
+// from somewhere other than the working tree, such as a git revision.
+type Files interface {
+	ReadFile(path string) ([]byte, error)
+	Glob(pattern string) ([]string, error)
+}
+
+type osFiles struct{}
+
+func (osFiles) ReadFile(path string) ([]byte, error)  { return os.ReadFile(path) }
+func (osFiles) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+
+func (l *loader) fs() Files {
+	if l.files == nil {
+		return osFiles{}
+	}
+	return l.files
+}
+
+func (l *loader) load(path string, includedAt Position) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	if prev, ok := l.seen[abs]; ok {
+		return &ConfigError{Pos: includedAt, Msg: fmt.Sprintf("%s is included more than once (first loaded as %s)", path, prev)}
+	}
+	l.seen[abs] = path
+
+	data := l.root
+	if l.cfg != nil || data == nil {
+		if data, err = l.fs().ReadFile(path); err != nil {
+			return &ConfigError{Pos: includedAt, Msg: fmt.Sprintf("cannot read config file: %v", err)}
+		}
+	}
+
+	part, err := decode(data, path)
+	if err != nil {
+		return err
+	}
+
+	if l.cfg == nil {
+		l.cfg = part
+	} else if err := l.merge(part); err != nil {
+		return err
+	}
+
+	for i, pattern := range part.Include {
+		at := part.sources[fmt.Sprintf("include[%d]", i)]
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := l.fs().Glob(pattern)
+		if err != nil {
+			return &ConfigError{Pos: at, Msg: fmt.Sprintf("include[%d] is not a valid glob: %v", i, err)}
+		}
+		if len(matches) == 0 && !hasGlobMeta(pattern) {
+			return &ConfigError{Pos: at, Msg: fmt.Sprintf("include[%d]: %s does not exist", i, pattern)}
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if err := l.load(match, at); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergedFields are the top-level fields an included file may set.
+var mergedFields = []string{"include", "keys", "roles", "groups", "hosts", "sops"}
+
+// merge adds the definitions of an included file to the root config.
+func (l *loader) merge(part *Config) error {
+	cfg := l.cfg
+	for _, field := range yamlFields(reflect.TypeOf(Config{})) {
+		if slices.Contains(mergedFields, field.name) {
+			continue
+		}
+		if pos, ok := part.sources[field.name]; ok {
+			return &ConfigError{Path: field.name, Pos: pos, Msg: fmt.Sprintf("%s may only be set in the root config", field.name)}
+		}
+	}
+
+	conflict := func(section, name string) error {
+		path := section + "." + name
+		return &ConfigError{Path: path, Pos: part.sources[path], Msg: fmt.Sprintf("%s is already defined at %s", path, cfg.sources[path].String())}
+	}
+
+	for name, key := range part.Keys {
+		if _, ok := cfg.Keys[name]; ok {
+			return conflict("keys", name)
+		}
+		if cfg.Keys == nil {
+			cfg.Keys = make(map[string]*Key)
+		}
+		cfg.Keys[name] = key
+	}
+	for name, refs := range part.Roles {
+		if _, ok := cfg.Roles[name]; ok {
+			return conflict("roles", name)
+		}
+		if cfg.Roles == nil {
+			cfg.Roles = make(map[string][]string)
+		}
+		cfg.Roles[name] = refs
+	}
+	for name, group := range part.Groups {
+		if _, ok := cfg.Groups[name]; ok {
+			return conflict("groups", name)
+		}
+		if cfg.Groups == nil {
+			cfg.Groups = make(map[string]*Group)
+		}
+		cfg.Groups[name] = group
+	}
+	for name, host := range part.Hosts {
+		if _, ok := cfg.Hosts[name]; ok {
+			return conflict("hosts", name)
+		}
+		if cfg.Hosts == nil {
+			cfg.Hosts = make(map[string]*Host)
+		}
+		cfg.Hosts[name] = host
+	}
+
+	offset := 0
+	if part.Sops != nil {
+		if cfg.Sops == nil {
+			cfg.Sops = &Sops{}
+		}
+		offset = len(cfg.Sops.Rules)
+		cfg.Sops.Rules = append(cfg.Sops.Rules, part.Sops.Rules...)
+	}
+
+	for path, pos := range part.sources {
+		if path == "include" || strings.HasPrefix(path, "include[") {
+			continue
+		}
+		if offset > 0 {
+			path = shiftRuleIndex(path, offset)
+		}
+		if _, ok := cfg.sources[path]; !ok {
+			cfg.sources[path] = pos
+		}
+	}
+	for _, path := range part.unknown {
+		if offset > 0 {
+			path = shiftRuleIndex(path, offset)
+		}
+		cfg.unknown = append(cfg.unknown, path)
+	}
+	for path, raw := range part.raw {
+		if offset > 0 {
+			path = shiftRuleIndex(path, offset)
+		}
+		if _, ok := cfg.raw[path]; !ok {
+			cfg.raw[path] = raw
+		}
+	}
+	return nil
+}
+
+var ruleIndex = regexp.MustCompile(`^sops\.rules\[(\d+)\]`)
+
+// shiftRuleIndex renumbers a sops.rules[i] path by offset.
+func shiftRuleIndex(path string, offset int) string {
+	m := ruleIndex.FindStringSubmatchIndex(path)
+	if m == nil {
+		return path
+	}
+	i, _ := strconv.Atoi(path[m[2]:m[3]])
+	return fmt.Sprintf("sops.rules[%d]", i+offset) + path[m[1]:]
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// decode parses one config file and records the source position of every
+// field. name labels positions and may be empty.
+func decode(data []byte, name string) (*Config, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &ConfigError{Pos: Position{File: name}, Msg: fmt.Sprintf("invalid YAML: %v", err)}
+	}
+
+	cfg := &Config{sources: make(map[string]Position), raw: make(map[string]rawValue)}
+	if len(doc.Content) == 0 {
+		return cfg, nil
+	}
+	if err := expandEnv(doc.Content[0], name, "", cfg.raw); err != nil {
+		return nil, err
+	}
+	if err := doc.Decode(cfg); err != nil {
+		return nil, &ConfigError{Pos: Position{File: name}, Msg: fmt.Sprintf("invalid YAML: %v", err)}
+	}
+	recordSources(doc.Content[0], name, "", cfg.sources)
+	cfg.unknown = unknownFields(doc.Content[0], reflect.TypeOf(Config{}), "")
+	return cfg, nil
+}
+
+func recordSources(node *yaml.Node, name, path string, sources map[string]Position) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child := key.Value
+			if path != "" {
+				child = path + "." + key.Value
+			}
+			sources[child] = Position{File: name, Line: key.Line, Column: key.Column}
+			recordSources(value, name, child, sources)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			sources[child] = Position{File: name, Line: item.Line, Column: item.Column}
+			recordSources(item, name, child, sources)
+		}
+	}
+}
+
+// Position is a location in a config file.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// String returns file:line:column, line:column without a file name, or ""
+// for an unknown position.
+func (p Position) String() string {
+	if p.Line == 0 {
+		return ""
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+func (p Position) before(q Position) bool {
+	if p.File != q.File {
+		return p.File < q.File
+	}
+	if p.Line != q.Line {
+		return p.Line < q.Line
+	}
+	return p.Column < q.Column
+}
+
+// source returns where path or, failing that, its nearest enclosing field is
+// defined.
+func (c *Config) source(path string) Position {
+	for path != "" {
+		if pos, ok := c.sources[path]; ok {
+			return pos
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return Position{}
+}
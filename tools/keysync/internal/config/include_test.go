@@ -0,0 +1,217 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const includeRoot = `version: 2
+vault: Personal
+include:
+  - hosts/*.yaml
+keys:
+  identity:
+    title: keysync/gpg/identity
+    fingerprint: 57F09591B972D7CD2D8EAC22515584B240B685E5
+    subkeys:
+      signing:
+        fingerprint: 1EC935423DEED8090E00DEF9DD7ABAD676F208CB
+`
+
+const includeHost = `hosts:
+  hylia:
+    keys:
+      - identity.signing
+`
+
+// writeFiles writes files, keyed by slash-separated path, under a temporary
+// directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestIncludeRootOnlyFields sets every top-level field the loader does not
+// merge in an included file, which must be an error rather than silently
+// dropped, including fields added to Config later.
+func TestIncludeRootOnlyFields(t *testing.T) {
+	for _, field := range yamlFields(reflect.TypeOf(Config{})) {
+		if slices.Contains(mergedFields, field.name) {
+			continue
+		}
+		t.Run(field.name, func(t *testing.T) {
+			var value string
+			switch field.typ.Kind() {
+			case reflect.String:
+				value = "x"
+			case reflect.Int:
+				value = "2"
+			case reflect.Bool:
+				value = "true"
+			case reflect.Slice:
+				value = "[]"
+			default:
+				value = "{}"
+			}
+			dir := writeFiles(t, map[string]string{
+				"keysync.yaml":     includeRoot,
+				"hosts/hylia.yaml": includeHost + field.name + ": " + value + "\n",
+			})
+
+			_, err := Load(filepath.Join(dir, "keysync.yaml"))
+			if err == nil || !strings.Contains(err.Error(), field.name+" may only be set in the root config") {
+				t.Fatalf("Load error = %v, want %s rejected in the include", err, field.name)
+			}
+			if !strings.Contains(err.Error(), "hylia.yaml:5:1") {
+				t.Errorf("Load error = %v, want the include's position", err)
+			}
+		})
+	}
+}
+
+func TestIncludeMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+		check   func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "hosts and sops rules",
+			files: map[string]string{
+				"keysync.yaml": includeRoot + `sops:
+  rules:
+    - path_regex: ^secrets/shared/
+      hosts: ["*"]
+`,
+				"hosts/hylia.yaml": includeHost + `sops:
+  rules:
+    - path_regex: ^secrets/hylia/
+      hosts: [hylia]
+`,
+				"hosts/lanayru.yaml": `hosts:
+  lanayru:
+    keys:
+      - identity.signing
+sops:
+  rules:
+    - path_regex: ^secrets/lanayru/
+      hosts: [lanayru]
+`,
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if got := cfg.AllHostNames(); !slices.Equal(got, []string{"hylia", "lanayru"}) {
+					t.Errorf("hosts = %v, want [hylia lanayru]", got)
+				}
+				var got []string
+				for _, rule := range cfg.Sops.Rules {
+					got = append(got, rule.PathRegex)
+				}
+				want := []string{"^secrets/shared/", "^secrets/hylia/", "^secrets/lanayru/"}
+				if !slices.Equal(got, want) {
+					t.Errorf("sops rules = %v, want %v", got, want)
+				}
+				if pos := cfg.source("sops.rules[2].hosts"); !strings.HasSuffix(pos.File, "lanayru.yaml") || pos.Line != 8 {
+					t.Errorf("source of sops.rules[2].hosts = %v, want lanayru.yaml line 8", pos)
+				}
+			},
+		},
+		{
+			name: "error reported in the include",
+			files: map[string]string{
+				"keysync.yaml": includeRoot,
+				"hosts/hylia.yaml": `hosts:
+  hylia:
+    keys:
+      - identity.missing
+`,
+			},
+			wantErr: "hylia.yaml:4:9",
+		},
+		{
+			name: "host defined twice",
+			files: map[string]string{
+				"keysync.yaml":       includeRoot,
+				"hosts/hylia.yaml":   includeHost,
+				"hosts/lanayru.yaml": includeHost,
+			},
+			wantErr: "lanayru.yaml:2:3: hosts.hylia is already defined at ",
+		},
+		{
+			name: "key defined in the root and an include",
+			files: map[string]string{
+				"keysync.yaml": includeRoot,
+				"hosts/hylia.yaml": includeHost + `keys:
+  identity:
+    title: other
+`,
+			},
+			wantErr: "keys.identity is already defined at ",
+		},
+		{
+			name: "file included twice",
+			files: map[string]string{
+				"keysync.yaml":       strings.Replace(includeRoot, "  - hosts/*.yaml\n", "  - hosts/*.yaml\n  - hosts/hylia.yaml\n", 1),
+				"hosts/hylia.yaml":   includeHost,
+				"hosts/lanayru.yaml": "",
+			},
+			wantErr: "hosts/hylia.yaml is included more than once",
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"keysync.yaml":     includeRoot,
+				"hosts/hylia.yaml": includeHost + "include:\n  - ../keysync.yaml\n",
+			},
+			wantErr: "keysync.yaml is included more than once",
+		},
+		{
+			name: "missing file",
+			files: map[string]string{
+				"keysync.yaml": strings.Replace(includeRoot, "hosts/*.yaml", "hosts/hylia.yaml", 1),
+			},
+			wantErr: "keysync.yaml:4:5: include[0]:",
+		},
+		{
+			name: "glob without matches",
+			files: map[string]string{
+				"keysync.yaml": includeRoot + includeHost,
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if got := cfg.AllHostNames(); !slices.Equal(got, []string{"hylia"}) {
+					t.Errorf("hosts = %v, want [hylia]", got)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			cfg, err := Load(filepath.Join(dir, "keysync.yaml"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Migrate converts a version 1 config to version 2 in place. Hosts that list
+// the same references, once their own key name is written as "self", share a
+// role; a host with a list of its own keeps it under keys. The expanded
+// references of every host are unchanged.
+func (c *Config) Migrate() error {
+	if c.Version != 1 {
+		return &ConfigError{Msg: fmt.Sprintf("cannot migrate config version %d; only version 1 is supported", c.Version)}
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	before := make(map[string][]string, len(c.Hosts))
+	for _, hostName := range c.AllHostNames() {
+		before[hostName] = append([]string{}, c.Hosts[hostName].Refs()...)
+	}
+
+	// Group hosts by their references with the host's own key name as self.
+	var lists [][]string
+	var members [][]string
+	for _, hostName := range c.AllHostNames() {
+		normalized := selfRefs(hostName, c.Hosts[hostName].Refs())
+		found := false
+		for i, list := range lists {
+			if sameRefs(list, normalized) {
+				members[i] = append(members[i], hostName)
+				found = true
+				break
+			}
+		}
+		if !found {
+			lists = append(lists, normalized)
+			members = append(members, []string{hostName})
+		}
+	}
+
+	var shared []int
+	for i := range lists {
+		if len(members[i]) > 1 {
+			shared = append(shared, i)
+		}
+	}
+
+	roles := make(map[string][]string)
+	for n, i := range shared {
+		name := "default"
+		if len(shared) > 1 {
+			name = fmt.Sprintf("role-%d", n+1)
+		}
+		roles[name] = lists[i]
+		for _, hostName := range members[i] {
+			host := c.Hosts[hostName]
+			host.Roles = []string{name}
+			host.Keys = nil
+		}
+	}
+
+	c.Version = 2
+	if len(roles) > 0 {
+		c.Roles = roles
+	}
+	for _, host := range c.Hosts {
+		host.refs = nil
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("migrated config is invalid: %w", err)
+	}
+
+	for hostName, refs := range before {
+		if !sameRefs(refs, c.Hosts[hostName].Refs()) {
+			return fmt.Errorf("migration changed the references of host %s: %s became %s", hostName, strings.Join(refs, ", "), strings.Join(c.Hosts[hostName].Refs(), ", "))
+		}
+	}
+	return nil
+}
+
+// selfRefs rewrites references to the key named after the host as self.
+func selfRefs(hostName string, refs []string) []string {
+	out := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if keyName, rest, ok := strings.Cut(ref, "."); ok && keyName == hostName {
+			ref = SelfKey + "." + rest
+		}
+		out = append(out, ref)
+	}
+	return out
+}
+
+func sameRefs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
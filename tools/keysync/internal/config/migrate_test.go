@@ -0,0 +1,134 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const migrateKeys = `version: 1
+vault: Personal
+keys:
+  identity:
+    title: keysync/gpg/identity
+    fingerprint: 57F09591B972D7CD2D8EAC22515584B240B685E5
+    subkeys:
+      signing:
+        fingerprint: 1EC935423DEED8090E00DEF9DD7ABAD676F208CB
+      encryption:
+        fingerprint: 3C0E4E0F2A8E5C1B8F7D6A5B4C3D2E1F0A9B8C7D
+  hylia:
+    title: keysync/gpg/hylia
+    fingerprint: 0A1B2C3D4E5F60718293A4B5C6D7E8F901234567
+    subkeys:
+      signing:
+        fingerprint: 1A2B3C4D5E6F708192A3B4C5D6E7F80912345678
+  lanayru:
+    title: keysync/gpg/lanayru
+    fingerprint: 2B3C4D5E6F708192A3B4C5D6E7F8091234567890
+    subkeys:
+      signing:
+        fingerprint: 3C4D5E6F708192A3B4C5D6E7F809123456789012
+hosts:
+`
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name      string
+		hosts     string
+		wantRoles map[string][]string
+		// wantHosts maps each host to its roles, or to its own keys when it
+		// has no role.
+		wantHosts map[string][]string
+	}{
+		{
+			name: "identical hosts share the default role",
+			hosts: `  hylia:
+    keys: [identity.signing, identity.encryption]
+  lanayru:
+    keys: [identity.signing, identity.encryption]
+`,
+			wantRoles: map[string][]string{"default": {"identity.signing", "identity.encryption"}},
+			wantHosts: map[string][]string{"hylia": {"default"}, "lanayru": {"default"}},
+		},
+		{
+			name: "own keys become self",
+			hosts: `  hylia:
+    keys: [hylia.signing, identity.encryption]
+  lanayru:
+    keys: [lanayru.signing, identity.encryption]
+`,
+			wantRoles: map[string][]string{"default": {"self.signing", "identity.encryption"}},
+			wantHosts: map[string][]string{"hylia": {"default"}, "lanayru": {"default"}},
+		},
+		{
+			name: "distinct hosts keep their keys",
+			hosts: `  hylia:
+    keys: [hylia.signing]
+  lanayru:
+    keys: [identity.signing]
+`,
+			wantHosts: map[string][]string{"hylia": {"hylia.signing"}, "lanayru": {"identity.signing"}},
+		},
+		{
+			name: "several shared lists",
+			hosts: `  faron:
+    keys: [identity.signing]
+  hylia:
+    keys: [hylia.signing]
+  lanayru:
+    keys: [lanayru.signing]
+  ordon:
+    keys: [identity.signing]
+`,
+			wantRoles: map[string][]string{"role-1": {"identity.signing"}, "role-2": {"self.signing"}},
+			wantHosts: map[string][]string{"faron": {"role-1"}, "hylia": {"role-2"}, "lanayru": {"role-2"}, "ordon": {"role-1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse([]byte(migrateKeys + tt.hosts))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			before := make(map[string][]string)
+			for _, hostName := range cfg.AllHostNames() {
+				before[hostName] = cfg.Hosts[hostName].Refs()
+			}
+
+			if err := cfg.Migrate(); err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			if cfg.Version != 2 {
+				t.Errorf("version = %d, want 2", cfg.Version)
+			}
+			if !maps.EqualFunc(cfg.Roles, tt.wantRoles, slices.Equal) {
+				t.Errorf("roles = %v, want %v", cfg.Roles, tt.wantRoles)
+			}
+			for hostName, want := range tt.wantHosts {
+				host := cfg.Hosts[hostName]
+				got := host.Roles
+				if len(got) == 0 {
+					got = host.Keys
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("host %s = %v, want %v", hostName, got, want)
+				}
+				if refs := host.Refs(); !slices.Equal(refs, before[hostName]) {
+					t.Errorf("host %s refs = %v, want %v", hostName, refs, before[hostName])
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateVersion2(t *testing.T) {
+	cfg, err := Parse([]byte(strings.Replace(migrateKeys, "version: 1", "version: 2", 1) + "  hylia:\n    keys: [hylia.signing]\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := cfg.Migrate(); err == nil || !strings.Contains(err.Error(), "only version 1 is supported") {
+		t.Errorf("Migrate error = %v, want version 2 rejected", err)
+	}
+}
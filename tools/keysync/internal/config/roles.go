@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SelfKey is the key name in role references that stands for the key named
+// after the host.
+const SelfKey = "self"
+
+// HostRefs returns a host's references expanded from its roles, groups and
+// keys, as Validate expands them, without checking that they resolve.
+func (c *Config) HostRefs(hostName string) ([]string, error) {
+	host, err := c.GetHost(hostName)
+	if err != nil {
+		return nil, err
+	}
+	refs, _, expandErr := c.expandHostRefs(hostName, host)
+	if expandErr != nil {
+		return nil, expandErr
+	}
+	return refs, nil
+}
+
+// expandHostRefs returns a host's references in order: its own roles, the
+// roles of the groups it belongs to by group name, then its keys. In version 2
+// configs "self" is replaced by the host name. Duplicates keep their first
+// position. origins holds the config path each reference came from.
+func (c *Config) expandHostRefs(hostName string, host *Host) (refs, origins []string, err *ConfigError) {
+	seen := make(map[string]struct{})
+	add := func(ref, origin string) {
+		if c.Version >= 2 {
+			if keyName, rest, ok := strings.Cut(ref, "."); ok && keyName == SelfKey {
+				ref = hostName + "." + rest
+			}
+		}
+		if _, ok := seen[ref]; ok {
+			return
+		}
+		seen[ref] = struct{}{}
+		refs = append(refs, ref)
+		origins = append(origins, origin)
+	}
+	addRole := func(role, origin string) *ConfigError {
+		roleRefs, ok := c.Roles[role]
+		if !ok {
+			return fieldError(origin, "references unknown role %q", role)
+		}
+		for i, ref := range roleRefs {
+			add(ref, fmt.Sprintf("roles.%s[%d]", role, i))
+		}
+		return nil
+	}
+
+	for i, role := range host.Roles {
+		if err := addRole(role, fmt.Sprintf("hosts.%s.roles[%d]", hostName, i)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	groupNames := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	for _, name := range groupNames {
+		group := c.Groups[name]
+		if group == nil || !containsName(group.Hosts, hostName) {
+			continue
+		}
+		for i, role := range group.Roles {
+			if err := addRole(role, fmt.Sprintf("groups.%s.roles[%d]", name, i)); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	for i, ref := range host.Keys {
+		add(ref, fmt.Sprintf("hosts.%s.keys[%d]", hostName, i))
+	}
+	return refs, origins, nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
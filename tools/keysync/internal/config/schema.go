@@ -0,0 +1,227 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nonBlank matches values Validate does not treat as missing.
+const nonBlank = `\S`
+
+// schemaFields adds constraints Validate enforces to the generated property
+// schemas, keyed by type and YAML field name.
+var schemaFields = map[string]map[string]any{
+	"Config.version": {"enum": supportedVersions},
+	"Config.vault":   {"pattern": nonBlank, "description": "1Password vault that holds the key items"},
+	"Config.account": {"description": "1Password account of the vault, for example my.1password.com"},
+	"Config.include": {"description": "further config files or globs, relative to this file, merged into it"},
+	"Config.keys":    {"minProperties": 1},
+	"Config.hosts":   {"minProperties": 1},
+	"Config.roles": {
+		"description":          "named lists of key references that hosts compose (version 2)",
+		"additionalProperties": map[string]any{"type": "array", "minItems": 1, "items": refSchema()},
+	},
+	"Config.groups": {"description": "give several hosts the same roles (version 2)"},
+
+	"Key.title":       {"pattern": nonBlank, "description": "1Password item title; may be a template using .Vault, .KeyName and .SubkeyName"},
+	"Key.fingerprint": {"pattern": fingerprintPattern.String()},
+	"Key.trust":       {"enum": trustLevels},
+	"Subkey.fingerprint": {
+		"pattern": fingerprintPattern.String(),
+	},
+
+	"KeySpec.uid":             {"pattern": nonBlank},
+	"KeySpec.subkeys":         {"minProperties": 1},
+	"SubkeySpec.capabilities": {"pattern": capabilitiesPattern.String()},
+
+	"Host.keys":  {"items": refSchema()},
+	"Host.roles": {"description": "names of top-level roles (version 2)"},
+	"Host.passphrase_policy": {
+		"enum":        passphrasePolicies,
+		"description": "how synced secret subkeys are protected (default inherit)",
+	},
+	"Host.public_keys": {"description": "keys whose public part the host imports"},
+
+	"Group.hosts": {"minItems": 1},
+
+	"SopsRule.path_regex": {"pattern": nonBlank, "format": "regex"},
+}
+
+// schemaTypes adds constraints that span several fields of a type. Hosts are
+// left out: a host may get all of its roles from a group, which only Validate
+// can see.
+var schemaTypes = map[string]map[string]any{
+	// A key needs a fingerprint and subkeys unless keysync generate creates
+	// it from its spec.
+	"Key": {"anyOf": []any{
+		map[string]any{"required": []string{"fingerprint", "subkeys"}, "properties": map[string]any{"subkeys": map[string]any{"minProperties": 1}}},
+		map[string]any{"required": []string{"spec"}},
+	}},
+	"SopsRule": {"anyOf": []any{
+		map[string]any{"required": []string{"hosts"}, "properties": map[string]any{"hosts": map[string]any{"minItems": 1}}},
+		map[string]any{"required": []string{"keys"}, "properties": map[string]any{"keys": map[string]any{"minItems": 1}}},
+	}},
+}
+
+func refSchema() map[string]any {
+	return map[string]any{"type": "string", "pattern": refPattern.String(), "description": "key.subkey reference"}
+}
+
+// Schema returns a JSON Schema for the config file, generated from the config
+// types: fields without omitempty are required and unknown fields are
+// rejected, as Validate does.
+func Schema() ([]byte, error) {
+	defs := make(map[string]any)
+	root, err := structSchema(reflect.TypeOf(Config{}), defs)
+	if err != nil {
+		return nil, err
+	}
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "keysync config"
+	root["$defs"] = defs
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode schema: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+func typeSchema(t reflect.Type, defs map[string]any) (map[string]any, error) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), defs)
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Int:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Slice, reflect.Map:
+		elem, err := typeSchema(t.Elem(), defs)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind() == reflect.Slice {
+			return map[string]any{"type": "array", "items": elem}, nil
+		}
+		return map[string]any{"type": "object", "additionalProperties": elem}, nil
+	case reflect.Struct:
+		name := strings.ToLower(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := defs[name]; !ok {
+			defs[name] = nil
+			schema, err := structSchema(t, defs)
+			if err != nil {
+				return nil, err
+			}
+			defs[name] = schema
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}, nil
+	}
+	return nil, fmt.Errorf("cannot generate a schema for %s", t)
+}
+
+func structSchema(t reflect.Type, defs map[string]any) (map[string]any, error) {
+	props := make(map[string]any)
+	var required []string
+	for _, field := range yamlFields(t) {
+		prop, err := typeSchema(field.typ, defs)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), field.name, err)
+		}
+		for k, v := range schemaFields[t.Name()+"."+field.name] {
+			prop[k] = v
+		}
+		props[field.name] = prop
+		if !field.omitempty {
+			required = append(required, field.name)
+		}
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	for k, v := range schemaTypes[t.Name()] {
+		schema[k] = v
+	}
+	return schema, nil
+}
+
+type yamlField struct {
+	name      string
+	typ       reflect.Type
+	omitempty bool
+}
+
+// yamlFields returns the fields of a config struct as YAML encodes them.
+func yamlFields(t reflect.Type) []yamlField {
+	var fields []yamlField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields = append(fields, yamlField{name: name, typ: f.Type, omitempty: strings.Contains(opts, "omitempty")})
+	}
+	return fields
+}
+
+// unknownFields returns the paths of mapping keys in node that t has no field
+// for, which decoding would silently drop.
+func unknownFields(node *yaml.Node, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var unknown []string
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			switch t.Kind() {
+			case reflect.Map:
+				unknown = append(unknown, unknownFields(value, t.Elem(), child)...)
+			case reflect.Struct:
+				field, ok := findYAMLField(t, key)
+				if !ok {
+					unknown = append(unknown, child)
+					continue
+				}
+				unknown = append(unknown, unknownFields(value, field.typ, child)...)
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice {
+			for i, item := range node.Content {
+				unknown = append(unknown, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return unknown
+}
+
+func findYAMLField(t reflect.Type, name string) (yamlField, bool) {
+	for _, field := range yamlFields(t) {
+		if field.name == name {
+			return field, true
+		}
+	}
+	return yamlField{}, false
+}
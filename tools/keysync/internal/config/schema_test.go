@@ -0,0 +1,228 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestSchemaMatchesValidate runs every fixture through Validate and the
+// generated schema, which must agree. Fixtures named valid-* must pass both;
+// the repository's own keysync.yaml is checked as well. References to
+// undefined keys, hosts and roles are left to Validate alone, so the invalid
+// fixtures only break rules the schema can express.
+func TestSchemaMatchesValidate(t *testing.T) {
+	data, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema: %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("schema is not JSON: %v", err)
+	}
+
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "schema", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures = append(fixtures, filepath.Join("..", "..", "..", "..", "keysync.yaml"))
+
+	for _, path := range fixtures {
+		name := filepath.Base(path)
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantValid := !strings.HasPrefix(name, "invalid-")
+
+			_, validateErr := Parse(data)
+			if (validateErr == nil) != wantValid {
+				t.Errorf("Validate: valid = %v, want %v: %v", validateErr == nil, wantValid, validateErr)
+			}
+
+			var doc any
+			if err := yaml.Unmarshal(data, &doc); err != nil {
+				t.Fatal(err)
+			}
+			// A JSON round trip gives the values the types a JSON Schema
+			// validator sees.
+			encoded, err := json.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value any
+			if err := json.Unmarshal(encoded, &value); err != nil {
+				t.Fatal(err)
+			}
+
+			problems := (&schemaChecker{root: schema}).check(schema, value, "")
+			if (len(problems) == 0) != wantValid {
+				t.Errorf("schema: valid = %v, want %v: %s", len(problems) == 0, wantValid, strings.Join(problems, "; "))
+			}
+		})
+	}
+}
+
+// TestSchemaFileUpToDate checks that the committed keysync.schema.json is what
+// keysync config schema generates.
+func TestSchemaFileUpToDate(t *testing.T) {
+	want, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "keysync.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("keysync.schema.json is out of date; regenerate it with keysync config schema")
+	}
+}
+
+// schemaChecker evaluates the subset of JSON Schema that Schema generates.
+type schemaChecker struct {
+	root map[string]any
+}
+
+// annotations are keywords that do not constrain values.
+var annotations = []string{"$schema", "$defs", "title", "description", "format"}
+
+func (c *schemaChecker) check(schema map[string]any, value any, path string) []string {
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf("%s: %s", defaultPath(path), fmt.Sprintf(format, args...)))
+	}
+
+	for keyword, arg := range schema {
+		switch keyword {
+		case "$ref":
+			name := strings.TrimPrefix(arg.(string), "#/$defs/")
+			def := c.root["$defs"].(map[string]any)[name].(map[string]any)
+			problems = append(problems, c.check(def, value, path)...)
+		case "type":
+			if !hasType(value, arg.(string)) {
+				fail("is not of type %s", arg)
+				return problems
+			}
+		case "enum":
+			if !slices.ContainsFunc(arg.([]any), func(v any) bool { return reflect.DeepEqual(v, value) }) {
+				fail("%v is not one of %v", value, arg)
+			}
+		case "pattern":
+			if s, ok := value.(string); ok && !regexp.MustCompile(arg.(string)).MatchString(s) {
+				fail("%q does not match %s", s, arg)
+			}
+		case "minItems":
+			if items, ok := value.([]any); ok && float64(len(items)) < arg.(float64) {
+				fail("has fewer than %v items", arg)
+			}
+		case "minProperties":
+			if props, ok := value.(map[string]any); ok && float64(len(props)) < arg.(float64) {
+				fail("has fewer than %v properties", arg)
+			}
+		case "required":
+			if props, ok := value.(map[string]any); ok {
+				for _, name := range arg.([]any) {
+					if _, ok := props[name.(string)]; !ok {
+						fail("is missing %s", name)
+					}
+				}
+			}
+		case "items":
+			if items, ok := value.([]any); ok {
+				for i, item := range items {
+					problems = append(problems, c.check(arg.(map[string]any), item, fmt.Sprintf("%s[%d]", path, i))...)
+				}
+			}
+		case "properties":
+			if props, ok := value.(map[string]any); ok {
+				for name, sub := range arg.(map[string]any) {
+					if v, ok := props[name]; ok {
+						problems = append(problems, c.check(sub.(map[string]any), v, joinSchemaPath(path, name))...)
+					}
+				}
+			}
+		case "additionalProperties":
+			props, ok := value.(map[string]any)
+			if !ok {
+				continue
+			}
+			known, _ := schema["properties"].(map[string]any)
+			for name, v := range props {
+				if _, ok := known[name]; ok {
+					continue
+				}
+				switch arg := arg.(type) {
+				case bool:
+					if !arg {
+						fail("%s is not allowed", name)
+					}
+				case map[string]any:
+					problems = append(problems, c.check(arg, v, joinSchemaPath(path, name))...)
+				}
+			}
+		case "anyOf":
+			matched := false
+			for _, sub := range arg.([]any) {
+				if len(c.check(sub.(map[string]any), value, path)) == 0 {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				fail("matches none of anyOf")
+			}
+		default:
+			if !slices.Contains(annotations, keyword) {
+				panic("schemaChecker does not support " + keyword)
+			}
+		}
+	}
+	return problems
+}
+
+func hasType(value any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	}
+	panic("schemaChecker does not support type " + typ)
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func defaultPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
@@ -0,0 +1,420 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var (
+	fingerprintPattern  = regexp.MustCompile(`^[0-9A-Fa-f]{40}$`)
+	refPattern          = regexp.MustCompile(`^[^.\s]+\.[^.\s]+$`)
+	capabilitiesPattern = regexp.MustCompile(`^[sea]+$`)
+)
+
+// Allowed values, shared with Schema.
+var (
+	supportedVersions  = []int{1, 2}
+	trustLevels        = TrustLevels
+	passphrasePolicies = []string{PassphraseInherit, PassphraseGenerate, PassphraseNone}
+)
+
+// ValidationError lists every error Validate found, sorted by source
+// position.
+type ValidationError struct {
+	Problems []*ConfigError
+}
+
+// Error returns one problem per line.
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, p.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap exposes the individual problems to errors.As.
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		errs = append(errs, p)
+	}
+	return errs
+}
+
+// Validate checks the configuration for required fields and valid references
+// and reports every error at once, each with the source position of the
+// field it is about.
+func (c *Config) Validate() error {
+	var errs []*ConfigError
+	for _, p := range c.check() {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: errs}
+}
+
+// check runs every validation and lint check and returns the problems sorted
+// by source position, then message.
+func (c *Config) check() []*ConfigError {
+	v := &validator{c: c}
+	v.renderTitles()
+	v.checkUnknown()
+	v.checkTop()
+	v.checkKeys()
+	v.checkFingerprints()
+	v.checkRoles()
+	v.checkHosts()
+	v.checkSops()
+	v.checkUnused()
+
+	for _, p := range v.problems {
+		if p.Pos.Line == 0 && p.Path != "" {
+			p.Pos = c.source(p.Path)
+		}
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if (a.Pos.Line == 0) != (b.Pos.Line == 0) {
+			return a.Pos.Line != 0
+		}
+		if a.Pos != b.Pos {
+			return a.Pos.before(b.Pos)
+		}
+		return a.Msg < b.Msg
+	})
+	return v.problems
+}
+
+// normalize upper-cases fingerprints after validation.
+func (c *Config) normalize() {
+	for _, key := range c.Keys {
+		key.Fingerprint = strings.ToUpper(strings.TrimSpace(key.Fingerprint))
+		for _, sub := range key.Subkeys {
+			sub.Fingerprint = strings.ToUpper(strings.TrimSpace(sub.Fingerprint))
+		}
+	}
+}
+
+type validator struct {
+	c        *Config
+	problems []*ConfigError
+}
+
+func (v *validator) errorf(path, format string, args ...any) {
+	v.problems = append(v.problems, fieldError(path, format, args...))
+}
+
+func (v *validator) warnf(path, format string, args ...any) {
+	p := fieldError(path, format, args...)
+	p.Warning = true
+	v.problems = append(v.problems, p)
+}
+
+// checkUnknown reports fields the config types do not define, which
+// decoding would otherwise ignore.
+func (v *validator) checkUnknown() {
+	for _, path := range v.c.unknown {
+		v.errorf(path, "is not a known field")
+	}
+}
+
+func (v *validator) checkTop() {
+	c := v.c
+	if !slices.Contains(supportedVersions, c.Version) {
+		v.errorf("version", "%d is not supported (expected 1 or 2)", c.Version)
+	}
+	if strings.TrimSpace(c.Vault) == "" {
+		v.errorf("vault", "is required")
+	}
+	if len(c.Keys) == 0 {
+		v.errorf("keys", "must not be empty")
+	}
+	if len(c.Hosts) == 0 {
+		v.errorf("hosts", "must not be empty")
+	}
+}
+
+func (v *validator) checkKeys() {
+	for _, name := range sortedKeys(v.c.Keys) {
+		key := v.c.Keys[name]
+		path := "keys." + name
+		if key == nil {
+			v.errorf(path, "is null")
+			continue
+		}
+		if strings.TrimSpace(key.Title) == "" {
+			v.errorf(path+".title", "is required")
+		}
+		if key.Trust != "" && !slices.Contains(trustLevels, key.Trust) {
+			v.errorf(path+".trust", "must be one of unknown, never, marginal, full or ultimate: %q", key.Trust)
+		}
+		if key.Spec != nil {
+			v.checkSpec(path+".spec", key.Spec)
+		}
+		if strings.TrimSpace(key.Fingerprint) == "" && !key.Pending() {
+			v.errorf(path+".fingerprint", "is required")
+		}
+		if len(key.Subkeys) == 0 && key.Spec == nil {
+			v.errorf(path+".subkeys", "must not be empty")
+		}
+		for _, subName := range sortedKeys(key.Subkeys) {
+			sub := key.Subkeys[subName]
+			subPath := path + ".subkeys." + subName
+			if sub == nil {
+				v.errorf(subPath, "is null")
+				continue
+			}
+			if strings.TrimSpace(sub.Fingerprint) == "" {
+				v.errorf(subPath+".fingerprint", "is required")
+			}
+		}
+	}
+}
+
+func (v *validator) checkSpec(path string, spec *KeySpec) {
+	if strings.TrimSpace(spec.UID) == "" {
+		v.errorf(path+".uid", "is required")
+	}
+	if len(spec.Subkeys) == 0 {
+		v.errorf(path+".subkeys", "must not be empty")
+	}
+	for _, subName := range sortedKeys(spec.Subkeys) {
+		sub := spec.Subkeys[subName]
+		subPath := path + ".subkeys." + subName
+		if sub == nil {
+			v.errorf(subPath, "is null")
+			continue
+		}
+		if strings.TrimSpace(sub.Capabilities) == "" {
+			v.errorf(subPath+".capabilities", "is required")
+		} else if !capabilitiesPattern.MatchString(sub.Capabilities) {
+			v.errorf(subPath+".capabilities", "must only contain s, e or a: %q", sub.Capabilities)
+		}
+	}
+}
+
+// checkFingerprints checks the format of every fingerprint and that no
+// fingerprint is used twice.
+func (v *validator) checkFingerprints() {
+	seen := make(map[string]string)
+	check := func(path, fp, primary string) {
+		fp = strings.TrimSpace(fp)
+		if fp == "" {
+			return
+		}
+		if !fingerprintPattern.MatchString(fp) {
+			v.errorf(path, "must be 40 hexadecimal characters: %q", fp)
+			return
+		}
+		if fp != strings.ToUpper(fp) {
+			v.warnf(path, "should be upper case: %q", fp)
+		}
+		upper := strings.ToUpper(fp)
+		if primary != "" && upper == strings.ToUpper(strings.TrimSpace(primary)) {
+			v.errorf(path, "equals the primary key fingerprint")
+			return
+		}
+		if first, ok := seen[upper]; ok {
+			v.errorf(path, "duplicates %s", first)
+			return
+		}
+		seen[upper] = path
+	}
+
+	for _, name := range sortedKeys(v.c.Keys) {
+		key := v.c.Keys[name]
+		if key == nil {
+			continue
+		}
+		check("keys."+name+".fingerprint", key.Fingerprint, "")
+		for _, subName := range sortedKeys(key.Subkeys) {
+			if sub := key.Subkeys[subName]; sub != nil {
+				check("keys."+name+".subkeys."+subName+".fingerprint", sub.Fingerprint, key.Fingerprint)
+			}
+		}
+	}
+}
+
+func (v *validator) checkRoles() {
+	c := v.c
+	if c.Version < 2 {
+		if len(c.Roles) > 0 {
+			v.errorf("roles", "needs version: 2; run keysync config migrate")
+		}
+		if len(c.Groups) > 0 {
+			v.errorf("groups", "needs version: 2; run keysync config migrate")
+		}
+		for _, hostName := range sortedKeys(c.Hosts) {
+			if host := c.Hosts[hostName]; host != nil && len(host.Roles) > 0 {
+				v.errorf("hosts."+hostName+".roles", "needs version: 2; run keysync config migrate")
+			}
+		}
+		return
+	}
+
+	for _, name := range sortedKeys(c.Roles) {
+		if len(c.Roles[name]) == 0 {
+			v.errorf("roles."+name, "must not be empty")
+		}
+	}
+
+	for _, name := range sortedKeys(c.Groups) {
+		group := c.Groups[name]
+		path := "groups." + name
+		if group == nil {
+			v.errorf(path, "is null")
+			continue
+		}
+		if len(group.Hosts) == 0 {
+			v.errorf(path+".hosts", "must not be empty")
+		}
+		for i, hostName := range group.Hosts {
+			if _, ok := c.Hosts[hostName]; !ok {
+				v.errorf(fmt.Sprintf("%s.hosts[%d]", path, i), "references unknown host %q", hostName)
+			}
+		}
+		for i, role := range group.Roles {
+			if _, ok := c.Roles[role]; !ok {
+				v.errorf(fmt.Sprintf("%s.roles[%d]", path, i), "references unknown role %q", role)
+			}
+		}
+	}
+}
+
+func (v *validator) checkHosts() {
+	c := v.c
+	for _, hostName := range sortedKeys(c.Hosts) {
+		host := c.Hosts[hostName]
+		path := "hosts." + hostName
+		if host == nil {
+			v.errorf(path, "is null")
+			continue
+		}
+		if !slices.Contains(passphrasePolicies, host.Policy()) {
+			v.errorf(path+".passphrase_policy", "must be inherit, generate or none: %q", host.PassphrasePolicy)
+		}
+		refs, origins, err := c.expandHostRefs(hostName, host)
+		if err != nil {
+			v.problems = append(v.problems, err)
+			continue
+		}
+		if len(refs) == 0 {
+			if c.Version == 1 {
+				v.errorf(path+".keys", "must not be empty")
+			} else {
+				v.errorf(path, "must have roles or keys")
+			}
+		}
+		valid := true
+		for i, keyRef := range refs {
+			if !refPattern.MatchString(keyRef) {
+				v.errorf(origins[i], "must be dot notation key.subkey: %q", keyRef)
+				valid = false
+				continue
+			}
+			if _, err := c.ResolveRef(keyRef); err != nil {
+				v.errorf(origins[i], "has invalid reference %q for host %s: %v", keyRef, hostName, err)
+				valid = false
+			}
+		}
+		if valid {
+			host.refs = refs
+		}
+		for i, keyName := range host.PublicKeys {
+			if _, ok := c.Keys[keyName]; !ok {
+				v.errorf(fmt.Sprintf("%s.public_keys[%d]", path, i), "references unknown key %q", keyName)
+			}
+		}
+	}
+}
+
+func (v *validator) checkSops() {
+	c := v.c
+	if c.Sops == nil {
+		return
+	}
+	for i, rule := range c.Sops.Rules {
+		path := fmt.Sprintf("sops.rules[%d]", i)
+		if rule == nil {
+			v.errorf(path, "is null")
+			continue
+		}
+		if strings.TrimSpace(rule.PathRegex) == "" {
+			v.errorf(path+".path_regex", "is required")
+		} else if _, err := regexp.Compile(rule.PathRegex); err != nil {
+			v.errorf(path+".path_regex", "is invalid: %v", err)
+		}
+		if len(rule.Hosts) == 0 && len(rule.Keys) == 0 {
+			v.errorf(path, "needs at least one host or key")
+		}
+		for j, hostName := range rule.Hosts {
+			if hostName == "*" {
+				continue
+			}
+			if _, ok := c.Hosts[hostName]; !ok {
+				v.errorf(fmt.Sprintf("%s.hosts[%d]", path, j), "references unknown host %q", hostName)
+			}
+		}
+		for j, keyName := range rule.Keys {
+			if _, ok := c.Keys[keyName]; !ok {
+				v.errorf(fmt.Sprintf("%s.keys[%d]", path, j), "references unknown key %q", keyName)
+			}
+		}
+	}
+}
+
+// checkUnused warns about keys that no host, public_keys entry or sops rule
+// uses.
+func (v *validator) checkUnused() {
+	c := v.c
+	used := make(map[string]struct{})
+	for _, hostName := range sortedKeys(c.Hosts) {
+		host := c.Hosts[hostName]
+		if host == nil {
+			continue
+		}
+		refs, _, err := c.expandHostRefs(hostName, host)
+		if err != nil {
+			continue
+		}
+		for _, ref := range refs {
+			used[refKeyName(ref)] = struct{}{}
+		}
+		for _, keyName := range host.PublicKeys {
+			used[keyName] = struct{}{}
+		}
+	}
+	if c.Sops != nil {
+		for _, rule := range c.Sops.Rules {
+			if rule == nil {
+				continue
+			}
+			for _, keyName := range rule.Keys {
+				used[keyName] = struct{}{}
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(c.Keys) {
+		if _, ok := used[name]; !ok {
+			v.warnf("keys."+name, "is not used by any host, public_keys entry or sops rule")
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
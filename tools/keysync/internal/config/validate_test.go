@@ -0,0 +1,93 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const validateBase = `version: 2
+vault: Personal
+keys:
+  identity:
+    title: keysync/gpg/identity
+    fingerprint: 57F09591B972D7CD2D8EAC22515584B240B685E5
+    subkeys:
+      signing:
+        fingerprint: 1EC935423DEED8090E00DEF9DD7ABAD676F208CB
+hosts:
+  hylia:
+    keys:
+      - identity.signing
+`
+
+func TestValidatePositions(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "valid",
+			data: validateBase,
+		},
+		{
+			name: "unknown field",
+			data: validateBase + "colour: blue\n",
+			want: []string{"14:1: colour is not a known field"},
+		},
+		{
+			name: "bad fingerprint and trust",
+			data: strings.Replace(validateBase, "    fingerprint: 57F0", "    trust: total\n    fingerprint: 57F0", 1),
+			want: []string{
+				`6:5: keys.identity.trust must be one of unknown, never, marginal, full or ultimate: "total"`,
+			},
+		},
+		{
+			name: "invalid reference",
+			data: strings.Replace(validateBase, "identity.signing", "identity.missing", 1),
+			want: []string{`13:9: hosts.hylia.keys[0] has invalid reference "identity.missing"`},
+		},
+		{
+			name: "every problem sorted by position",
+			data: strings.Replace(validateBase, "vault: Personal", "vault: \"\"", 1) + `sops:
+  rules:
+    - path_regex: "("
+      hosts: [lanayru]
+`,
+			want: []string{
+				"2:1: vault is required",
+				"16:7: sops.rules[0].path_regex is invalid",
+				`17:15: sops.rules[0].hosts[0] references unknown host "lanayru"`,
+			},
+		},
+		{
+			name: "missing field at its parent",
+			data: strings.Replace(validateBase, "    title: keysync/gpg/identity\n", "", 1),
+			want: []string{"4:3: keys.identity.title is required"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Parse: %v", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Parse error = %v, want a ValidationError", err)
+			}
+			if len(verr.Problems) != len(tt.want) {
+				t.Fatalf("got %d problems, want %d:\n%v", len(verr.Problems), len(tt.want), err)
+			}
+			for i, want := range tt.want {
+				if got := verr.Problems[i].Error(); !strings.HasPrefix(got, want) {
+					t.Errorf("problem %d = %q, want prefix %q", i, got, want)
+				}
+			}
+		})
+	}
+}
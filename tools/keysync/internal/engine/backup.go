@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/OnTheWehn333/keysync/internal/config"
@@ -15,17 +16,35 @@ func BackupKey(cfg *config.Config, keyName string) error {
 	if err != nil {
 		return err
 	}
+	if key.Pending() {
+		return &config.ConfigError{Msg: fmt.Sprintf("%s has not been generated yet; run keysync generate --key %s", keyName, keyName)}
+	}
+	return backupKey(keyName, key, keyVault(cfg, keyName))
+}
 
-	if err := op.EnsureSignedIn(); err != nil {
+// backupKey stores a full backup of key in vault, for keys that need not be in
+// the config any more.
+func backupKey(keyName string, key *config.Key, vault op.Vault) error {
+	if err := op.EnsureSignedIn(vault.Account); err != nil {
 		return err
 	}
 
+	existing, err := op.GetItem(key.Title, vault)
+	if err != nil {
+		return fmt.Errorf("failed to check 1Password item %q: %w", key.Title, err)
+	}
+
+	var passphrase string
+	if existing != nil {
+		passphrase = existing.FieldValue("passphrase")
+	}
+
 	pubKey, err := gpg.ExportPublicKey(key.Fingerprint)
 	if err != nil {
 		return fmt.Errorf("failed to export public key for %s: %w", keyName, err)
 	}
 
-	secKey, err := gpg.ExportSecretKey(key.Fingerprint)
+	secKey, err := gpg.ExportSecretKey(key.Fingerprint, passphrase)
 	if err != nil {
 		return fmt.Errorf("failed to export secret key for %s: %w", keyName, err)
 	}
@@ -35,43 +54,63 @@ func BackupKey(cfg *config.Config, keyName string) error {
 		return fmt.Errorf("failed to read key metadata for %s: %w", keyName, err)
 	}
 
-	pubHash := sha256Hex(pubKey)
-	secHash := sha256Hex(secKey)
-
-	existing, err := op.GetItem(key.Title, cfg.Vault)
+	trust, err := gpg.ExportOwnertrust(key.Fingerprint)
 	if err != nil {
-		return fmt.Errorf("failed to check 1Password item %q: %w", key.Title, err)
+		return fmt.Errorf("failed to read owner-trust for %s: %w", keyName, err)
 	}
 
+	pubHash := sha256Hex(pubKey)
+	secHash := sha256Hex(secKey)
+
+	var revCert string
 	if existing != nil {
-		if existing.FieldValue("sha256_public") == pubHash && existing.FieldValue("sha256_secret") == secHash {
+		revCert = existing.FieldValue("revocation_cert")
+		if existing.FieldValue("sha256_public") == pubHash && existing.FieldValue("sha256_secret") == secHash &&
+			existing.FieldValue("ownertrust") == trust && revCert != "" {
 			fmt.Printf("= %s unchanged\n", key.Title)
 			return nil
 		}
 	}
+	if revCert == "" {
+		cert, err := gpg.RevocationCertificate(key.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("failed to read revocation certificate for %s: %w", keyName, err)
+		}
+		if cert == nil {
+			// The secret key exported above, so gpg can create one.
+			if cert, err = gpg.GenerateRevocation(key.Fingerprint, gpg.ReasonUnspecified, ""); err != nil {
+				return fmt.Errorf("failed to generate revocation certificate for %s: %w", keyName, err)
+			}
+			fmt.Printf("~ %s has no stored revocation certificate; generated one\n", keyName)
+		}
+		revCert = string(cert)
+	}
 
 	fields := op.ItemFields{
-		Fingerprint:  key.Fingerprint,
-		Algorithm:    meta.Algorithm,
-		Capabilities: meta.Capabilities,
-		UID:          meta.UID,
-		Created:      meta.Created,
-		Expires:      meta.Expires,
-		PublicKey:    string(pubKey),
-		SecretKey:    string(secKey),
-		SHA256Public: pubHash,
-		SHA256Secret: secHash,
+		Fingerprint:    key.Fingerprint,
+		Algorithm:      meta.Algorithm,
+		Capabilities:   meta.Capabilities,
+		UID:            meta.UID,
+		Created:        meta.Created,
+		Expires:        meta.Expires,
+		PublicKey:      string(pubKey),
+		SecretKey:      string(secKey),
+		SHA256Public:   pubHash,
+		SHA256Secret:   secHash,
+		Ownertrust:     trust,
+		Passphrase:     passphrase,
+		RevocationCert: revCert,
 	}
 
 	if existing != nil {
-		if err := op.EditItem(key.Title, cfg.Vault, fields); err != nil {
+		if err := op.EditItem(key.Title, vault, fields); err != nil {
 			return fmt.Errorf("failed to update 1Password item %q: %w", key.Title, err)
 		}
 		fmt.Printf("- %s updated\n", key.Title)
 		return nil
 	}
 
-	if err := op.CreateItem(key.Title, cfg.Vault, fields); err != nil {
+	if err := op.CreateItem(key.Title, vault, fields); err != nil {
 		return fmt.Errorf("failed to create 1Password item %q: %w", key.Title, err)
 	}
 
@@ -81,12 +120,21 @@ func BackupKey(cfg *config.Config, keyName string) error {
 
 // BackupAll exports all top-level keys and stores full backups in 1Password.
 func BackupAll(cfg *config.Config) error {
-	if err := op.EnsureSignedIn(); err != nil {
+	keyNames := keysByAccount(cfg)
+	locs := make([]config.Location, 0, len(keyNames))
+	for _, keyName := range keyNames {
+		locs = append(locs, cfg.KeyLocation(keyName))
+	}
+	if err := op.EnsureSignedIn(config.Accounts(locs...)...); err != nil {
 		return err
 	}
 
 	var failures []string
-	for _, keyName := range cfg.AllKeyNames() {
+	for _, keyName := range keyNames {
+		if key, _ := cfg.GetKey(keyName); key.Pending() {
+			fmt.Printf("~ %s skipped (not generated yet)\n", keyName)
+			continue
+		}
 		if err := BackupKey(cfg, keyName); err != nil {
 			msg := fmt.Sprintf("%s: %v", keyName, err)
 			failures = append(failures, msg)
@@ -107,17 +155,21 @@ func BackupRestore(cfg *config.Config, keyName string, opts RestoreOpts) error {
 	if err != nil {
 		return err
 	}
+	if key.Pending() {
+		return &config.ConfigError{Msg: fmt.Sprintf("%s has not been generated yet; run keysync generate --key %s", keyName, keyName)}
+	}
 
-	if err := op.EnsureSignedIn(); err != nil {
+	vault := keyVault(cfg, keyName)
+	if err := op.EnsureSignedIn(vault.Account); err != nil {
 		return err
 	}
 
-	item, err := op.GetItem(key.Title, cfg.Vault)
+	item, err := op.GetItem(key.Title, vault)
 	if err != nil {
 		return fmt.Errorf("failed to fetch %s from 1Password: %w", key.Title, err)
 	}
 	if item == nil {
-		return fmt.Errorf("1Password item %q not found in vault %q", key.Title, cfg.Vault)
+		return fmt.Errorf("1Password item %q not found in vault %s", key.Title, vault)
 	}
 
 	publicKey := item.FieldValue("public_key")
@@ -154,14 +206,42 @@ func BackupRestore(cfg *config.Config, keyName string, opts RestoreOpts) error {
 		}
 	}
 
-	if err := gpg.ImportKey([]byte(publicKey)); err != nil {
+	passphrase := item.FieldValue("passphrase")
+
+	if err := gpg.ImportKey([]byte(publicKey), ""); err != nil {
 		return fmt.Errorf("failed to import public_key for %q: %w", key.Title, err)
 	}
 
-	if err := gpg.ImportKey([]byte(secretKey)); err != nil {
+	if err := gpg.ImportKey([]byte(secretKey), passphrase); err != nil {
 		return fmt.Errorf("failed to import secret_key for %q: %w", key.Title, err)
 	}
 
 	fmt.Printf("restored %s -> GPG keyring\n", key.Title)
-	return nil
+
+	if opts.PresetPassphrase {
+		if err := presetPassphrase(key.Fingerprint, "", passphrase); err != nil {
+			return err
+		}
+	}
+
+	return restoreOwnertrust(keyName, key, item)
+}
+
+// keyVault returns the vault holding a key's backup item.
+func keyVault(cfg *config.Config, keyName string) op.Vault {
+	return opVault(cfg.KeyLocation(keyName))
+}
+
+func opVault(loc config.Location) op.Vault {
+	return op.Vault{Name: loc.Vault, Account: loc.Account}
+}
+
+// keysByAccount returns all key names grouped by the account of their backup
+// item, so that op calls are batched per account.
+func keysByAccount(cfg *config.Config) []string {
+	names := cfg.AllKeyNames()
+	sort.SliceStable(names, func(i, j int) bool {
+		return cfg.KeyLocation(names[i]).Account < cfg.KeyLocation(names[j]).Account
+	})
+	return names
 }
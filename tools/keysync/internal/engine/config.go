@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+)
+
+// MigrateConfig converts a version 1 config file to version 2, or prints the
+// result without writing it when dryRun is set.
+func MigrateConfig(cfg *config.Config, cfgPath string, dryRun bool) error {
+	if err := cfg.Migrate(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		doc, err := config.OpenDocument(cfgPath)
+		if err != nil {
+			return err
+		}
+		if err := doc.Update(cfg); err != nil {
+			return err
+		}
+		data, err := doc.Bytes()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if err := cfg.Save(cfgPath); err != nil {
+		return err
+	}
+	fmt.Printf("- %s migrated to version %d\n", cfgPath, cfg.Version)
+	return nil
+}
+
+// LintConfig prints every error and warning in a config file and its
+// includes, and fails when there are errors. data, when set, is linted in
+// place of the file's contents.
+func LintConfig(cfgPath string, data []byte) error {
+	problems, err := config.LintData(cfgPath, data)
+	if err != nil {
+		return err
+	}
+
+	var errs []*config.ConfigError
+	for _, p := range problems {
+		fmt.Println(p.Error())
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	if len(errs) > 0 {
+		return &config.ConfigError{Msg: fmt.Sprintf("%s has %d errors and %d warnings", cfgPath, len(errs), len(problems)-len(errs))}
+	}
+	if len(problems) == 0 {
+		fmt.Printf("= %s ok\n", cfgPath)
+	}
+	return nil
+}
+
+// RenderConfig prints the effective config: included files merged in, and
+// environment variables, title templates and overrides resolved.
+func RenderConfig(cfg *config.Config) error {
+	cfg.Include = nil
+	data, err := cfg.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// SetConfig replaces the value at a config path, such as
+// hosts.faron.passphrase_policy. The value is parsed as YAML.
+func SetConfig(cfgPath, path, value string) error {
+	doc, err := config.OpenDocument(cfgPath)
+	if err != nil {
+		return err
+	}
+	node, err := config.ParseValue(value)
+	if err != nil {
+		return err
+	}
+
+	changed, err := doc.Set(path, node)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Printf("= %s unchanged\n", path)
+		return nil
+	}
+	if err := doc.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("- %s updated\n", path)
+	return nil
+}
+
+// AddConfig appends values to the list at a config path, such as
+// hosts.faron.keys, skipping values already listed.
+func AddConfig(cfgPath, path string, values []string) error {
+	doc, err := config.OpenDocument(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	var added []string
+	for _, value := range values {
+		node, err := config.ParseValue(value)
+		if err != nil {
+			return err
+		}
+		n, err := doc.Add(path, node)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			fmt.Printf("= %s %s unchanged\n", path, value)
+			continue
+		}
+		added = append(added, value)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	if err := doc.Save(); err != nil {
+		return err
+	}
+	for _, value := range added {
+		fmt.Printf("+ %s %s\n", path, value)
+	}
+	return nil
+}
+
+// RemoveConfig deletes the entry at a config path or, when values are given,
+// those values from the list at the path.
+func RemoveConfig(cfgPath, path string, values []string) error {
+	doc, err := config.OpenDocument(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	if len(values) == 0 {
+		ok, err := doc.Remove(path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &config.ConfigError{Path: path, Msg: fmt.Sprintf("%s does not exist", path)}
+		}
+		if err := doc.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("- %s removed\n", path)
+		return nil
+	}
+
+	var removed []string
+	for _, value := range values {
+		node, err := config.ParseValue(value)
+		if err != nil {
+			return err
+		}
+		n, err := doc.RemoveValues(path, node)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			fmt.Printf("~ %s %s not found\n", path, value)
+			continue
+		}
+		removed = append(removed, value)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	if err := doc.Save(); err != nil {
+		return err
+	}
+	for _, value := range removed {
+		fmt.Printf("- %s %s removed\n", path, value)
+	}
+	return nil
+}
+
+// WriteSchema prints the config JSON Schema, or writes it to path when path
+// is set.
+func WriteSchema(path string) error {
+	data, err := config.Schema()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return writeGenerated(path, data)
+}
+
+// CheckSchema reports an error when the schema at path differs from what
+// keysync config schema would write.
+func CheckSchema(path string) error {
+	data, err := config.Schema()
+	if err != nil {
+		return err
+	}
+	ok, err := checkGenerated(path, data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is out of date; run keysync config schema --output %s", path, path)
+	}
+	return nil
+}
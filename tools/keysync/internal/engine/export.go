@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"fmt"
+	"net/mail"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/wkd"
+)
+
+// Public keyring export formats.
+const (
+	ExportArmor  = "armor"
+	ExportBinary = "binary"
+	ExportWKD    = "wkd"
+	ExportDir    = "dir"
+)
+
+// ExportPublicOpts controls public keyring export.
+type ExportPublicOpts struct {
+	// Host limits the export to the keys the host references.
+	Host   string
+	Format string
+	// Output is the bundle file for armor and binary, and the directory for
+	// dir and wkd.
+	Output string
+}
+
+// ExportPublic writes the public keys of the configured keys, or of one host's
+// keys, as an armored or binary bundle, a directory of <fingerprint>.asc files,
+// or a Web Key Directory tree with one <domain>/.well-known/openpgpkey per mail
+// domain.
+func ExportPublic(cfg *config.Config, opts ExportPublicOpts) error {
+	keyNames, err := exportKeyNames(cfg, opts.Host)
+	if err != nil {
+		return err
+	}
+
+	var fps []string
+	for _, keyName := range keyNames {
+		fps = append(fps, cfg.Keys[keyName].Fingerprint)
+	}
+	if len(fps) == 0 {
+		return &config.ConfigError{Msg: "no generated keys to export"}
+	}
+
+	output := opts.Output
+	if output == "" {
+		output = defaultExportOutput(opts.Format)
+	}
+
+	switch opts.Format {
+	case ExportArmor, ExportBinary:
+		data, err := gpg.ExportPublicKeys(opts.Format == ExportArmor, fps...)
+		if err != nil {
+			return fmt.Errorf("failed to export public keys: %w", err)
+		}
+		return writeGenerated(output, data)
+	case ExportDir:
+		for _, keyName := range keyNames {
+			fp := cfg.Keys[keyName].Fingerprint
+			data, err := gpg.ExportPublicKey(fp)
+			if err != nil {
+				return fmt.Errorf("failed to export public key for %s: %w", keyName, err)
+			}
+			if err := writeGenerated(filepath.Join(output, strings.ToUpper(fp)+".asc"), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ExportWKD:
+		return exportWKD(cfg, keyNames, output)
+	default:
+		return &config.ConfigError{Msg: fmt.Sprintf("unknown export format %q: want armor, binary, wkd or dir", opts.Format)}
+	}
+}
+
+// exportKeyNames returns the generated keys to export, sorted: every key, or
+// the keys referenced by hostName.
+func exportKeyNames(cfg *config.Config, hostName string) ([]string, error) {
+	var candidates []string
+	if hostName == "" {
+		candidates = cfg.AllKeyNames()
+	} else {
+		host, err := cfg.GetHost(hostName)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]struct{})
+		for _, ref := range host.Refs() {
+			resolved, err := cfg.ResolveRef(ref)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := seen[resolved.KeyName]; !ok {
+				seen[resolved.KeyName] = struct{}{}
+				candidates = append(candidates, resolved.KeyName)
+			}
+		}
+		sort.Strings(candidates)
+	}
+
+	var keyNames []string
+	for _, keyName := range candidates {
+		if cfg.Keys[keyName].Pending() {
+			fmt.Printf("~ %s skipped (not generated yet)\n", keyName)
+			continue
+		}
+		keyNames = append(keyNames, keyName)
+	}
+	return keyNames, nil
+}
+
+func defaultExportOutput(format string) string {
+	switch format {
+	case ExportArmor:
+		return "keysync-public.asc"
+	case ExportBinary:
+		return "keysync-public.gpg"
+	case ExportWKD:
+		return "openpgpkey"
+	default:
+		return "keysync-public"
+	}
+}
+
+// exportWKD writes one direct-method key file per mail address found in the
+// keys' user IDs. Keys without a mail address are skipped.
+func exportWKD(cfg *config.Config, keyNames []string, root string) error {
+	byAddress := make(map[string][]string)
+	for _, keyName := range keyNames {
+		fp := cfg.Keys[keyName].Fingerprint
+		infos, err := gpg.ListKeys(false, fp)
+		if err != nil {
+			return fmt.Errorf("failed to read user IDs for %s: %w", keyName, err)
+		}
+		if len(infos) == 0 {
+			return fmt.Errorf("no public key %s in keyring", fp)
+		}
+
+		var found bool
+		for _, uid := range infos[0].UIDs {
+			addr, err := mail.ParseAddress(uid)
+			if err != nil {
+				continue
+			}
+			address := strings.ToLower(addr.Address)
+			if !containsString(byAddress[address], fp) {
+				byAddress[address] = append(byAddress[address], fp)
+			}
+			found = true
+		}
+		if !found {
+			fmt.Printf("~ %s skipped (no mail address in user IDs)\n", keyName)
+		}
+	}
+	if len(byAddress) == 0 {
+		return &config.ConfigError{Msg: "no exported key has a user ID with a mail address"}
+	}
+
+	addresses := make([]string, 0, len(byAddress))
+	for address := range byAddress {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		localPart, domain, _ := strings.Cut(address, "@")
+		domainRoot := filepath.Join(root, domain)
+
+		data, err := gpg.ExportWKDKey(address, byAddress[address]...)
+		if err != nil {
+			return fmt.Errorf("failed to export WKD key for %s: %w", address, err)
+		}
+
+		keyPath := filepath.Join(domainRoot, filepath.FromSlash(wkd.Path(localPart)))
+		if err := writeGenerated(keyPath, data); err != nil {
+			return err
+		}
+		if err := writeGenerated(filepath.Join(domainRoot, filepath.FromSlash(wkd.PolicyPath)), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/op"
+)
+
+// GenerateKey creates the primary key and subkeys declared in a key's spec
+// and records their fingerprints in cfg.
+func GenerateKey(cfg *config.Config, keyName string) error {
+	key, err := cfg.GetKey(keyName)
+	if err != nil {
+		return err
+	}
+	if key.Spec == nil {
+		return &config.ConfigError{Msg: fmt.Sprintf("keys.%s has no spec to generate from", keyName)}
+	}
+	if !key.Pending() {
+		return &config.ConfigError{Msg: fmt.Sprintf("keys.%s already has a fingerprint", keyName)}
+	}
+
+	spec := key.Spec
+	primaryFP, err := gpg.GenerateKey(spec.UID, defaultString(spec.Algorithm, "ed25519"), defaultString(spec.Expires, "never"))
+	if err != nil {
+		return fmt.Errorf("failed to generate primary key for %s: %w", keyName, err)
+	}
+	key.Fingerprint = primaryFP
+	fmt.Printf("+ %s primary %s\n", keyName, primaryFP)
+
+	subNames := make([]string, 0, len(spec.Subkeys))
+	for name := range spec.Subkeys {
+		subNames = append(subNames, name)
+	}
+	sort.Strings(subNames)
+
+	if key.Subkeys == nil {
+		key.Subkeys = make(map[string]*config.Subkey)
+	}
+	for _, subName := range subNames {
+		sub := spec.Subkeys[subName]
+		algorithm := sub.Algorithm
+		if algorithm == "" {
+			algorithm = "ed25519"
+			if strings.Contains(sub.Capabilities, "e") {
+				algorithm = "cv25519"
+			}
+		}
+
+		subFP, err := gpg.AddSubkey(primaryFP, algorithm, usageFromCapabilities(sub.Capabilities), defaultString(sub.Expires, defaultString(spec.Expires, "never")))
+		if err != nil {
+			// Without all of its subkeys the key is never saved to the
+			// config, so remove it rather than leave an orphan primary that
+			// a retry would duplicate.
+			key.Fingerprint = ""
+			key.Subkeys = nil
+			if delErr := gpg.DeleteKey(primaryFP); delErr != nil {
+				return fmt.Errorf("failed to generate subkey %s.%s: %w (and could not delete the partial key %s: %v)", keyName, subName, err, primaryFP, delErr)
+			}
+			fmt.Printf("x %s partial key %s deleted\n", keyName, primaryFP)
+			return fmt.Errorf("failed to generate subkey %s.%s: %w", keyName, subName, err)
+		}
+		key.Subkeys[subName] = &config.Subkey{Fingerprint: subFP}
+		fmt.Printf("+ %s.%s subkey %s\n", keyName, subName, subFP)
+	}
+
+	return nil
+}
+
+// Generate creates a spec-declared key, writes its fingerprints back to the
+// config file, and then backs up the key and syncs each of its subkeys.
+func Generate(cfg *config.Config, cfgPath, keyName string) error {
+	if err := cfg.CanSave(cfgPath); err != nil {
+		return err
+	}
+	if err := op.EnsureSignedIn(keyVault(cfg, keyName).Account); err != nil {
+		return err
+	}
+
+	if err := GenerateKey(cfg, keyName); err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Save(cfgPath); err != nil {
+		return err
+	}
+	fmt.Printf("- %s updated\n", cfgPath)
+
+	if err := BackupKey(cfg, keyName); err != nil {
+		return err
+	}
+
+	key, _ := cfg.GetKey(keyName)
+	for _, subName := range key.SubkeyNames() {
+		if err := syncRef(cfg, keyName+"."+subName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func usageFromCapabilities(caps string) string {
+	var usage []string
+	for _, c := range caps {
+		switch c {
+		case 's':
+			usage = append(usage, "sign")
+		case 'e':
+			usage = append(usage, "encr")
+		case 'a':
+			usage = append(usage, "auth")
+		}
+	}
+	return strings.Join(usage, ",")
+}
+
+func defaultString(value, fallback string) string {
+	if strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeGenerated writes data to path, creating its directory, unless the file
+// already holds it.
+func writeGenerated(path string, data []byte) error {
+	existing, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(existing, data) {
+		fmt.Printf("= %s unchanged\n", path)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("cannot create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("cannot write %s: %w", path, err)
+	}
+	fmt.Printf("- %s updated\n", path)
+	return nil
+}
+
+// checkGenerated reports whether path holds exactly data.
+func checkGenerated(path string, data []byte) (bool, error) {
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	if bytes.Equal(existing, data) {
+		fmt.Printf("= %s up to date\n", path)
+		return true, nil
+	}
+	fmt.Printf("! %s differs\n", path)
+	return false, nil
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/git"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+)
+
+// GitConfigureOpts controls git commit-signing configuration.
+type GitConfigureOpts struct {
+	// Scope is the git config scope: global, system, local or worktree.
+	Scope string
+	// Program is the gpg.program value. When empty, gpg.program is left unset
+	// so git runs the gpg on PATH; a value that still runs is kept.
+	Program string
+	// Check reports mismatches instead of writing the config.
+	Check bool
+}
+
+type gitSetting struct {
+	key string
+	// value is the value to set; empty unsets the key.
+	value string
+	same  func(current string) bool
+}
+
+// GitConfigure points git at the host's signing subkey: it sets
+// user.signingkey to the subkey fingerprint pinned with !, gpg.program when
+// one is given and commit.gpgsign in the chosen scope and makes a test signature. With Check it
+// only reports settings that differ.
+func GitConfigure(cfg *config.Config, hostName string, opts GitConfigureOpts) error {
+	scope := defaultString(opts.Scope, "global")
+	if !containsString(git.ConfigScopes, scope) {
+		return &config.ConfigError{Msg: fmt.Sprintf("unknown git config scope %q: want %s", scope, strings.Join(git.ConfigScopes, ", "))}
+	}
+
+	_, sign, err := hostCapabilitySubkeys(cfg, hostName)
+	if err != nil {
+		return err
+	}
+	if sign == nil {
+		return &config.ConfigError{Msg: fmt.Sprintf("hosts.%s references no signing subkey", hostName)}
+	}
+	signingKey := strings.ToUpper(sign.Fingerprint) + "!"
+
+	// The gpg on PATH is left to git rather than written out: on Nix it
+	// resolves to a store path that a later garbage collection removes.
+	program := opts.Program
+	programSetting := gitSetting{key: "gpg.program", value: program, same: func(current string) bool { return current == program }}
+	if program == "" {
+		if _, err := exec.LookPath("gpg"); err != nil {
+			return fmt.Errorf("cannot find gpg on PATH: %w", err)
+		}
+		programSetting.same = func(current string) bool {
+			_, err := exec.LookPath(current)
+			return err == nil
+		}
+	}
+
+	settings := []gitSetting{
+		{key: "user.signingkey", value: signingKey, same: func(current string) bool { return strings.EqualFold(current, signingKey) }},
+		programSetting,
+		{key: "commit.gpgsign", value: "true", same: gitBoolTrue},
+	}
+
+	var mismatches []string
+	for _, setting := range settings {
+		current, ok, err := git.ConfigGet(scope, setting.key)
+		if err != nil {
+			return err
+		}
+		if ok && setting.same(current) {
+			fmt.Printf("= %s %s\n", setting.key, current)
+			if setting.key == "gpg.program" {
+				program = current
+			}
+			continue
+		}
+		if !ok && setting.value == "" {
+			fmt.Printf("= %s unset\n", setting.key)
+			continue
+		}
+
+		if opts.Check {
+			got := "unset"
+			if ok {
+				got = fmt.Sprintf("%q", current)
+			}
+			want := "unset"
+			if setting.value != "" {
+				want = fmt.Sprintf("%q", setting.value)
+			}
+			msg := fmt.Sprintf("%s is %s, want %s", setting.key, got, want)
+			mismatches = append(mismatches, msg)
+			fmt.Printf("! %s\n", msg)
+			continue
+		}
+
+		if setting.value == "" {
+			if err := git.ConfigUnset(scope, setting.key); err != nil {
+				return err
+			}
+			fmt.Printf("x %s unset\n", setting.key)
+			continue
+		}
+		if err := git.ConfigSet(scope, setting.key, setting.value); err != nil {
+			return err
+		}
+		fmt.Printf("- %s %s\n", setting.key, setting.value)
+	}
+
+	if opts.Check {
+		if len(mismatches) > 0 {
+			return fmt.Errorf("git %s config differs for host %s: %s", scope, hostName, strings.Join(mismatches, "; "))
+		}
+		return nil
+	}
+
+	if err := gpg.TestSign(defaultString(program, "gpg"), signingKey); err != nil {
+		return fmt.Errorf("test signature failed: %w", err)
+	}
+	fmt.Printf("= test signature with %s\n", signingKey)
+	return nil
+}
+
+func gitBoolTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
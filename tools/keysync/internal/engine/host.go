@@ -0,0 +1,563 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/git"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/op"
+)
+
+// HostAddOpts controls host onboarding.
+type HostAddOpts struct {
+	UID       string
+	Algorithm string
+	Expires   string
+	// Refs are the host's key references, in place of the defaults. When
+	// empty, the roles or references every existing host has are used.
+	Refs []string
+}
+
+// AddHost generates a key for a new host, adds the key and host to the config,
+// backs up and syncs the key, and prints the remaining integration steps.
+func AddHost(cfg *config.Config, cfgPath, hostName string, opts HostAddOpts) error {
+	if err := cfg.CanSave(cfgPath); err != nil {
+		return err
+	}
+	if _, ok := cfg.Hosts[hostName]; ok {
+		return &config.ConfigError{Msg: fmt.Sprintf("host %q already exists", hostName)}
+	}
+	if _, ok := cfg.Keys[hostName]; ok {
+		return &config.ConfigError{Msg: fmt.Sprintf("key %q already exists", hostName)}
+	}
+
+	refs := opts.Refs
+	if len(refs) == 0 {
+		refs = sharedHostRefs(cfg)
+	}
+
+	uid := opts.UID
+	if uid == "" {
+		uid = hostName
+	}
+
+	cfg.Keys[hostName] = &config.Key{
+		Title: "keysync/gpg/" + hostName,
+		Spec: &config.KeySpec{
+			UID:       uid,
+			Algorithm: opts.Algorithm,
+			Expires:   opts.Expires,
+			Subkeys: map[string]*config.SubkeySpec{
+				"encrypt": {Capabilities: "e"},
+				"auth":    {Capabilities: "a"},
+			},
+		},
+	}
+	host := &config.Host{Keys: append([]string{}, refs...)}
+	if len(opts.Refs) == 0 && cfg.Version >= 2 {
+		if roles := sharedHostRoles(cfg); len(roles) > 0 {
+			host.Roles, host.Keys = roles, nil
+		}
+	}
+	addSopsHost(cfg, hostName)
+	cfg.Hosts[hostName] = host
+
+	hostRefs, err := cfg.HostRefs(hostName)
+	if err != nil {
+		return err
+	}
+	for _, ref := range []string{hostName + ".encrypt", hostName + ".auth"} {
+		if !containsString(hostRefs, ref) {
+			host.Keys = append(host.Keys, ref)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if err := op.EnsureSignedIn(keyVault(cfg, hostName).Account); err != nil {
+		return err
+	}
+
+	if err := GenerateKey(cfg, hostName); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Save(cfgPath); err != nil {
+		return err
+	}
+	fmt.Printf("- %s updated\n", cfgPath)
+
+	if err := BackupKey(cfg, hostName); err != nil {
+		return err
+	}
+	if err := SyncHost(cfg, hostName); err != nil {
+		return err
+	}
+
+	printHostFollowUp(cfg, cfgPath, hostName)
+	return nil
+}
+
+// sharedHostRefs returns the references, expanded from roles and groups,
+// that every configured host has, in the order the alphabetically first host
+// has them.
+func sharedHostRefs(cfg *config.Config) []string {
+	counts := make(map[string]int)
+	for _, host := range cfg.Hosts {
+		seen := make(map[string]struct{})
+		for _, ref := range host.Refs() {
+			if _, ok := seen[ref]; ok {
+				continue
+			}
+			seen[ref] = struct{}{}
+			counts[ref]++
+		}
+	}
+
+	names := cfg.AllHostNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	var shared []string
+	for _, ref := range cfg.Hosts[names[0]].Refs() {
+		if counts[ref] == len(cfg.Hosts) {
+			shared = append(shared, ref)
+		}
+	}
+	return shared
+}
+
+// sharedHostRoles returns the roles every configured host lists, in the
+// order the alphabetically first host lists them.
+func sharedHostRoles(cfg *config.Config) []string {
+	names := cfg.AllHostNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	var shared []string
+	for _, role := range cfg.Hosts[names[0]].Roles {
+		everywhere := true
+		for _, name := range names[1:] {
+			if !containsString(cfg.Hosts[name].Roles, role) {
+				everywhere = false
+				break
+			}
+		}
+		if everywhere && !containsString(shared, role) {
+			shared = append(shared, role)
+		}
+	}
+	return shared
+}
+
+// addSopsHost adds a secrets/<host> creation rule for a new host and adds the
+// host to every rule that lists all existing hosts. It must run before the
+// host is added to cfg.Hosts.
+func addSopsHost(cfg *config.Config, hostName string) {
+	if cfg.Sops == nil {
+		return
+	}
+
+	for _, rule := range cfg.Sops.Rules {
+		if len(rule.Hosts) > 0 && len(rule.Hosts) == len(cfg.Hosts) && !containsString(rule.Hosts, "*") {
+			rule.Hosts = append(rule.Hosts, hostName)
+		}
+	}
+	cfg.Sops.Rules = append(cfg.Sops.Rules, &config.SopsRule{
+		PathRegex: "secrets/" + hostName + "/.*",
+		Hosts:     []string{hostName},
+	})
+}
+
+// removeSopsHost drops a host from sops rules and removes rules left without
+// recipients.
+func removeSopsHost(cfg *config.Config, hostName string) {
+	if cfg.Sops == nil {
+		return
+	}
+
+	rules := cfg.Sops.Rules[:0]
+	for _, rule := range cfg.Sops.Rules {
+		hosts := rule.Hosts[:0]
+		for _, name := range rule.Hosts {
+			if name != hostName {
+				hosts = append(hosts, name)
+			}
+		}
+		rule.Hosts = hosts
+		if len(rule.Hosts) > 0 || len(rule.Keys) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	cfg.Sops.Rules = rules
+}
+
+// removeKey drops a key and the sops rule and public_keys entries naming it.
+func removeKey(cfg *config.Config, keyName string) {
+	delete(cfg.Keys, keyName)
+	if cfg.Sops != nil {
+		rules := cfg.Sops.Rules[:0]
+		for _, rule := range cfg.Sops.Rules {
+			rule.Keys = removeString(rule.Keys, keyName)
+			if len(rule.Hosts) > 0 || len(rule.Keys) > 0 {
+				rules = append(rules, rule)
+			}
+		}
+		cfg.Sops.Rules = rules
+	}
+	for _, host := range cfg.Hosts {
+		host.PublicKeys = removeString(host.PublicKeys, keyName)
+	}
+}
+
+func removeString(values []string, value string) []string {
+	kept := values[:0]
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// removeGroupHost drops a host from groups and removes groups left without
+// hosts.
+func removeGroupHost(cfg *config.Config, hostName string) {
+	for name, group := range cfg.Groups {
+		hosts := group.Hosts[:0]
+		for _, h := range group.Hosts {
+			if h != hostName {
+				hosts = append(hosts, h)
+			}
+		}
+		group.Hosts = hosts
+		if len(group.Hosts) == 0 {
+			delete(cfg.Groups, name)
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func printHostFollowUp(cfg *config.Config, cfgPath, hostName string) {
+	root := filepath.Dir(cfgPath)
+	sopsPath := filepath.Join(root, ".sops.yaml")
+
+	var steps []string
+	if cfg.Sops != nil {
+		steps = append(steps, fmt.Sprintf("render %s: keysync sops generate", sopsPath))
+		steps = append(steps, rekeyFollowUp(cfg, root, hostName))
+	} else {
+		steps = append(steps, fmt.Sprintf("add a creation rule for secrets/%s/.* to %s encrypting to %s", hostName, sopsPath, cfg.Keys[hostName].Fingerprint))
+		steps = append(steps, "re-encrypt the secrets the host needs: sops updatekeys <file>")
+	}
+	steps = append(steps, fmt.Sprintf("regenerate %s: keysync nix generate", filepath.Join(root, "hosts/shared")))
+	steps = append(steps, fmt.Sprintf("on the new machine: keysync restore --host %s", hostName))
+
+	fmt.Printf("\nhost %s onboarded; remaining integration steps:\n", hostName)
+	for i, step := range steps {
+		fmt.Printf("  %d. %s\n", i+1, step)
+	}
+}
+
+// rekeyFollowUp names the sops files whose creation rule now includes the new
+// host but that are not encrypted to it yet.
+func rekeyFollowUp(cfg *config.Config, root, hostName string) string {
+	rules, err := sopsRules(cfg)
+	if err != nil {
+		return fmt.Sprintf("re-encrypt the secrets the host needs: keysync sops rekey (%v)", err)
+	}
+	files, err := findSopsFiles(root, rules, nil)
+	if err != nil {
+		return fmt.Sprintf("re-encrypt the secrets the host needs: keysync sops rekey (%v)", err)
+	}
+
+	fp := strings.ToUpper(cfg.Keys[hostName].Fingerprint)
+	var stale []string
+	for _, file := range files {
+		if containsString(file.intended, fp) && !slices.ContainsFunc(file.current, func(current string) bool { return strings.EqualFold(current, fp) }) {
+			stale = append(stale, file.rel)
+		}
+	}
+	if len(stale) == 0 {
+		return "no existing sops files need re-encrypting for the host"
+	}
+	return "re-encrypt " + strings.Join(stale, ", ") + ": keysync sops rekey"
+}
+
+// HostRemoveOpts controls host decommissioning.
+type HostRemoveOpts struct {
+	Revoke bool
+	Reason gpg.RevocationReason
+	Purge  bool
+}
+
+// RemoveHost removes a host and the keys no other host uses from the config,
+// optionally revokes the subkeys of those keys, archives or deletes the
+// host's 1Password items and the keys' backup items, and reports files that
+// still reference the keys' fingerprints.
+func RemoveHost(cfg *config.Config, cfgPath, hostName string, opts HostRemoveOpts) error {
+	if err := cfg.CanSave(cfgPath); err != nil {
+		return err
+	}
+	host, err := cfg.GetHost(hostName)
+	if err != nil {
+		return err
+	}
+
+	owned := cfg.OwnedKeys(hostName)
+	ownedSet := make(map[string]struct{}, len(owned))
+	for _, keyName := range owned {
+		ownedSet[keyName] = struct{}{}
+	}
+
+	var hostRefs []*config.ResolvedRef
+	for _, ref := range host.Refs() {
+		resolved, err := cfg.ResolveHostRef(hostName, ref)
+		if err != nil {
+			return err
+		}
+		if _, ok := ownedSet[resolved.KeyName]; ok || resolved.Host != "" {
+			hostRefs = append(hostRefs, resolved)
+		}
+	}
+
+	// Owned keys are looked up from here on, as they leave the config with
+	// the host.
+	keys := make(map[string]*config.Key, len(owned))
+	keyLocs := make(map[string]config.Location, len(owned))
+	for _, keyName := range owned {
+		keys[keyName] = cfg.Keys[keyName]
+		keyLocs[keyName] = cfg.KeyLocation(keyName)
+	}
+
+	delete(cfg.Hosts, hostName)
+	removeGroupHost(cfg, hostName)
+	removeSopsHost(cfg, hostName)
+	for _, keyName := range owned {
+		removeKey(cfg, keyName)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	locs := make([]config.Location, 0, len(hostRefs)+len(owned))
+	for _, resolved := range hostRefs {
+		locs = append(locs, resolved.Location)
+	}
+	for _, keyName := range owned {
+		locs = append(locs, keyLocs[keyName])
+	}
+	if err := op.EnsureSignedIn(config.Accounts(locs...)...); err != nil {
+		return err
+	}
+
+	// The config is saved before anything in the keyring or vault changes, so
+	// that a config that cannot be saved leaves both untouched.
+	if err := cfg.Save(cfgPath); err != nil {
+		return err
+	}
+	fmt.Printf("- %s updated\n", cfgPath)
+
+	if opts.Revoke {
+		for _, keyName := range owned {
+			key := keys[keyName]
+			if key.Pending() {
+				continue
+			}
+			for _, subName := range key.SubkeyNames() {
+				subFP := key.Subkeys[subName].Fingerprint
+				if err := gpg.RevokeSubkey(key.Fingerprint, subFP, opts.Reason, "host "+hostName+" decommissioned"); err != nil {
+					return fmt.Errorf("failed to revoke %s.%s: %w", keyName, subName, err)
+				}
+				fmt.Printf("x %s.%s revoked\n", keyName, subName)
+			}
+			if err := backupKey(keyName, key, opVault(keyLocs[keyName])); err != nil {
+				return err
+			}
+		}
+	}
+
+	type retiredItem struct {
+		title string
+		vault op.Vault
+	}
+	var retired []retiredItem
+	for _, resolved := range hostRefs {
+		retired = append(retired, retiredItem{resolved.ItemTitle, opVault(resolved.Location)})
+	}
+	for _, keyName := range owned {
+		if key := keys[keyName]; !key.Pending() {
+			retired = append(retired, retiredItem{key.Title, opVault(keyLocs[keyName])})
+		}
+	}
+
+	suffix := " (archived " + time.Now().UTC().Format("2006-01-02") + ")"
+	for _, r := range retired {
+		item, err := op.GetItem(r.title, r.vault)
+		if err != nil {
+			return fmt.Errorf("failed to check 1Password item %q: %w", r.title, err)
+		}
+		if item == nil {
+			fmt.Printf("~ %s not found\n", r.title)
+			continue
+		}
+
+		if opts.Purge {
+			if err := op.DeleteItem(r.title, r.vault); err != nil {
+				return fmt.Errorf("failed to delete 1Password item %q: %w", r.title, err)
+			}
+			fmt.Printf("- %s deleted\n", r.title)
+			continue
+		}
+
+		if err := op.ArchiveItem(r.title, r.vault, r.title+suffix, []string{"keysync-archived"}); err != nil {
+			return fmt.Errorf("failed to archive 1Password item %q: %w", r.title, err)
+		}
+		fmt.Printf("- %s archived\n", r.title)
+	}
+
+	refs, err := findReferences(filepath.Dir(cfgPath), referenceNeedles(keys))
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		fmt.Printf("\nno files reference %s fingerprints\n", hostName)
+		return nil
+	}
+
+	fmt.Printf("\nfiles still referencing %s fingerprints:\n", hostName)
+	for _, ref := range refs {
+		fmt.Printf("  %s\n", ref)
+	}
+	return nil
+}
+
+// referenceNeedles maps the fingerprints, keygrips and SSH key comments of
+// the given keys to a label naming what they belong to.
+func referenceNeedles(keys map[string]*config.Key) map[string]string {
+	needles := make(map[string]string)
+	for keyName, key := range keys {
+		if key.Pending() {
+			continue
+		}
+		needles[strings.ToUpper(key.Fingerprint)] = keyName
+		for _, subName := range key.SubkeyNames() {
+			subFP := strings.ToUpper(key.Subkeys[subName].Fingerprint)
+			needles[subFP] = keyName + "." + subName
+			if len(subFP) == 40 {
+				needles["OPENPGP:0X"+subFP[32:]] = keyName + "." + subName + " ssh key"
+			}
+		}
+
+		infos, err := gpg.ListKeys(false, key.Fingerprint)
+		if err != nil || len(infos) == 0 {
+			continue
+		}
+		if infos[0].Keygrip != "" {
+			needles[infos[0].Keygrip] = keyName + " keygrip"
+		}
+		for _, subName := range key.SubkeyNames() {
+			if sub := infos[0].Find(key.Subkeys[subName].Fingerprint); sub != nil && sub.Keygrip != "" {
+				needles[sub.Keygrip] = keyName + "." + subName + " keygrip"
+			}
+		}
+	}
+	return needles
+}
+
+// findReferences scans the text files git tracks under root and returns
+// "path:line: label" entries for every line that contains one of the needles.
+func findReferences(root string, needles map[string]string) ([]string, error) {
+	if len(needles) == 0 {
+		return nil, nil
+	}
+
+	files, err := git.LsFiles(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s for references: %w", root, err)
+	}
+
+	var refs []string
+	for _, name := range files {
+		found, err := fileReferences(filepath.Join(root, name), needles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s for references: %w", root, err)
+		}
+		for _, ref := range found {
+			refs = append(refs, name+":"+ref)
+		}
+	}
+	return refs, nil
+}
+
+// fileReferences reads a file line by line and returns "line: label" entries
+// for the lines that contain one of the needles. Binary files, which have a
+// NUL byte near the start, and tracked files missing from the working tree
+// are skipped.
+func fileReferences(path string, needles map[string]string) ([]string, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 8000)
+	if head, _ := r.Peek(8000); bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var refs []string
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			upper := strings.ToUpper(line)
+			var labels []string
+			for needle, label := range needles {
+				if strings.Contains(upper, needle) {
+					labels = append(labels, label)
+				}
+			}
+			if len(labels) > 0 {
+				sort.Strings(labels)
+				refs = append(refs, fmt.Sprintf("%d: %s", lineNo, strings.Join(labels, ", ")))
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return refs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
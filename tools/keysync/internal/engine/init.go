@@ -0,0 +1,281 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+)
+
+// InitKey selects one keyring primary key for keysync init. An empty Name is
+// derived from the key's user ID.
+type InitKey struct {
+	Name        string
+	Fingerprint string
+}
+
+// InitOpts controls config scaffolding.
+type InitOpts struct {
+	Keys  []InitKey
+	Host  string
+	Vault string
+	// Add merges the keys into an existing config instead of refusing to
+	// overwrite it.
+	Add bool
+	// Interactive prompts for anything not given by flags.
+	Interactive bool
+	In          io.Reader
+}
+
+// Init scaffolds a config from secret keys in the local keyring: each chosen
+// primary becomes a key named by its user ID with subkeys named by capability,
+// and every subkey is referenced by one host. When cfgPath exists, the keys
+// are added to it.
+func Init(cfgPath string, opts InitOpts) error {
+	in := bufio.NewReader(opts.In)
+
+	var cfg *config.Config
+	if _, err := os.Stat(cfgPath); err == nil {
+		add := opts.Add
+		if !add && opts.Interactive {
+			add = confirm(in, fmt.Sprintf("%s exists; add the new keys to it?", cfgPath))
+		}
+		if !add {
+			return &config.ConfigError{Msg: fmt.Sprintf("%s already exists; use --add to add keys to it", cfgPath)}
+		}
+		if cfg, err = config.Load(cfgPath); err != nil {
+			return err
+		}
+		if err := cfg.CanSave(cfgPath); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot read %s: %w", cfgPath, err)
+	}
+
+	infos, err := gpg.ListKeys(true)
+	if err != nil {
+		return fmt.Errorf("failed to list secret keys: %w", err)
+	}
+	var available []*gpg.KeyInfo
+	for _, info := range infos {
+		if usableKey(info) && (cfg == nil || !configHasFingerprint(cfg, info.Fingerprint)) {
+			available = append(available, info)
+		}
+	}
+
+	selected := opts.Keys
+	if len(selected) == 0 {
+		if !opts.Interactive {
+			return &config.ConfigError{Msg: "no keys selected; pass --key or run interactively"}
+		}
+		if selected, err = promptKeys(in, available); err != nil {
+			return err
+		}
+	}
+
+	if cfg == nil {
+		vault := opts.Vault
+		if vault == "" && opts.Interactive {
+			vault = prompt(in, "1Password vault", "Personal")
+		}
+		cfg = &config.Config{Version: 1, Vault: vault, Keys: map[string]*config.Key{}, Hosts: map[string]*config.Host{}}
+	}
+
+	hostName := opts.Host
+	if hostName == "" {
+		hostName, _ = os.Hostname()
+		if opts.Interactive {
+			hostName = prompt(in, "host name", hostName)
+		}
+	}
+	host := cfg.Hosts[hostName]
+	if host == nil {
+		host = &config.Host{}
+		cfg.Hosts[hostName] = host
+	}
+
+	for _, choice := range selected {
+		info := findKeyInfo(available, choice.Fingerprint)
+		if info == nil {
+			return &config.ConfigError{Msg: fmt.Sprintf("no usable secret key %s in the keyring that is not already configured", choice.Fingerprint)}
+		}
+
+		name := choice.Name
+		if name == "" {
+			name = keyNameFromUIDs(info.UIDs)
+			if opts.Interactive {
+				name = prompt(in, "name for "+info.Fingerprint, name)
+			}
+		}
+		if name == "" {
+			return &config.ConfigError{Msg: fmt.Sprintf("cannot derive a key name for %s; pass --key NAME=%s", info.Fingerprint, info.Fingerprint)}
+		}
+		if _, ok := cfg.Keys[name]; ok {
+			return &config.ConfigError{Msg: fmt.Sprintf("key %q already exists", name)}
+		}
+
+		key := &config.Key{
+			Title:       "keysync/gpg/" + name,
+			Fingerprint: strings.ToUpper(info.Fingerprint),
+			Subkeys:     make(map[string]*config.Subkey),
+		}
+		for _, sub := range info.Subkeys {
+			if !usableKey(sub) {
+				continue
+			}
+			// Stubs and card keys have no secret to back up or sync.
+			switch {
+			case !sub.HasSecret:
+				fmt.Printf("~ %s skipped (no secret key in the keyring)\n", sub.Fingerprint)
+				continue
+			case sub.CardSerial != "":
+				fmt.Printf("~ %s skipped (secret key is on card %s)\n", sub.Fingerprint, sub.CardSerial)
+				continue
+			}
+			subName := uniqueName(key.Subkeys, subkeyName(sub.Capabilities))
+			key.Subkeys[subName] = &config.Subkey{Fingerprint: strings.ToUpper(sub.Fingerprint)}
+			host.Keys = append(host.Keys, name+"."+subName)
+			fmt.Printf("+ %s.%s %s\n", name, subName, sub.Fingerprint)
+		}
+		if len(key.Subkeys) == 0 {
+			return &config.ConfigError{Msg: fmt.Sprintf("key %s has no usable subkeys", info.Fingerprint)}
+		}
+		cfg.Keys[name] = key
+		fmt.Printf("+ %s %s\n", name, key.Fingerprint)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Save(cfgPath); err != nil {
+		return err
+	}
+	fmt.Printf("- %s updated\n", cfgPath)
+	return nil
+}
+
+// usableKey reports whether a key or subkey is neither revoked, expired nor
+// disabled.
+func usableKey(info *gpg.KeyInfo) bool {
+	switch info.Validity {
+	case "r", "e", "d", "i", "n":
+		return false
+	}
+	return true
+}
+
+func configHasFingerprint(cfg *config.Config, fp string) bool {
+	for _, key := range cfg.Keys {
+		if strings.EqualFold(key.Fingerprint, fp) {
+			return true
+		}
+	}
+	return false
+}
+
+func findKeyInfo(infos []*gpg.KeyInfo, fp string) *gpg.KeyInfo {
+	for _, info := range infos {
+		if strings.EqualFold(info.Fingerprint, fp) {
+			return info
+		}
+	}
+	return nil
+}
+
+// subkeyName names a subkey by its capabilities, for example signing,
+// encrypt, auth or signing-auth.
+func subkeyName(caps string) string {
+	caps = strings.ToLower(caps)
+	var parts []string
+	for _, c := range []struct {
+		letter, name string
+	}{{"s", "signing"}, {"e", "encrypt"}, {"a", "auth"}} {
+		if strings.Contains(caps, c.letter) {
+			parts = append(parts, c.name)
+		}
+	}
+	if len(parts) == 0 {
+		return "subkey"
+	}
+	return strings.Join(parts, "-")
+}
+
+func uniqueName(subkeys map[string]*config.Subkey, name string) string {
+	if _, ok := subkeys[name]; !ok {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := name + "-" + strconv.Itoa(i)
+		if _, ok := subkeys[candidate]; !ok {
+			return candidate
+		}
+	}
+}
+
+var nonNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// keyNameFromUIDs derives a key name from the first user ID: its name part,
+// else its mail local-part, lower-cased with other characters turned into
+// dashes.
+func keyNameFromUIDs(uids []string) string {
+	if len(uids) == 0 {
+		return ""
+	}
+	uid := uids[0]
+	if addr, err := mail.ParseAddress(uid); err == nil {
+		uid = addr.Name
+		if uid == "" {
+			uid, _, _ = strings.Cut(addr.Address, "@")
+		}
+	}
+	return strings.Trim(nonNameChars.ReplaceAllString(strings.ToLower(uid), "-"), "-")
+}
+
+func promptKeys(in *bufio.Reader, available []*gpg.KeyInfo) ([]InitKey, error) {
+	if len(available) == 0 {
+		return nil, &config.ConfigError{Msg: "no unconfigured secret keys in the keyring"}
+	}
+
+	fmt.Println("secret keys in the keyring:")
+	for i, info := range available {
+		fmt.Printf("  %d) %s %s %s\n", i+1, info.Fingerprint, info.Algorithm, strings.Join(info.UIDs, ", "))
+	}
+
+	answer := prompt(in, "keys to add (numbers, comma-separated)", "1")
+	var selected []InitKey
+	for _, field := range strings.Split(answer, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n > len(available) {
+			return nil, &config.ConfigError{Msg: fmt.Sprintf("invalid selection %q", strings.TrimSpace(field))}
+		}
+		selected = append(selected, InitKey{Fingerprint: available[n-1].Fingerprint})
+	}
+	return selected, nil
+}
+
+func prompt(in *bufio.Reader, question, fallback string) string {
+	if fallback != "" {
+		fmt.Printf("%s [%s]: ", question, fallback)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, _ := in.ReadString('\n')
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return fallback
+}
+
+func confirm(in *bufio.Reader, question string) bool {
+	answer := strings.ToLower(prompt(in, question+" [y/N]", ""))
+	return answer == "y" || answer == "yes"
+}
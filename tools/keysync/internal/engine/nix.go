@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/nix"
+)
+
+// nixFile is one generated hosts/shared attrset.
+type nixFile struct {
+	name     string
+	comments []string
+	values   map[string]string
+}
+
+// GenerateNix writes the per-host SSH public key, auth keygrip and signing
+// subkey attrsets into dir.
+func GenerateNix(cfg *config.Config, dir string) error {
+	files, err := renderNixFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create %s: %w", dir, err)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		if err := writeGenerated(path, nix.RenderAttrset(file.comments, file.values)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckNix reports an error when the attrsets in dir differ from what
+// GenerateNix would write.
+func CheckNix(cfg *config.Config, dir string) error {
+	files, err := renderNixFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	var drifted []string
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		ok, err := checkGenerated(path, nix.RenderAttrset(file.comments, file.values))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			drifted = append(drifted, path)
+		}
+	}
+
+	if len(drifted) > 0 {
+		return fmt.Errorf("nix files out of date: %s; run keysync nix generate", strings.Join(drifted, ", "))
+	}
+	return nil
+}
+
+func renderNixFiles(cfg *config.Config) ([]nixFile, error) {
+	sshKeys := make(map[string]string)
+	keygrips := make(map[string]string)
+	signing := make(map[string]string)
+
+	for _, hostName := range cfg.AllHostNames() {
+		auth, sign, err := hostCapabilitySubkeys(cfg, hostName)
+		if err != nil {
+			return nil, err
+		}
+
+		if auth != nil {
+			sshKey, err := gpg.ExportSSHKey(auth.Fingerprint)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export ssh key for %s: %w", hostName, err)
+			}
+			sshKeys[hostName] = sshKey
+			keygrips[hostName] = auth.Keygrip
+		} else {
+			fmt.Printf("~ %s has no auth subkey\n", hostName)
+		}
+
+		if sign != nil {
+			signing[hostName] = sign.Fingerprint + "!"
+		} else {
+			fmt.Printf("~ %s has no signing subkey\n", hostName)
+		}
+	}
+
+	generated := "Generated by keysync nix generate from keysync.yaml; do not edit."
+	return []nixFile{
+		{
+			name:     "ssh-public-keys.nix",
+			comments: []string{generated, "SSH public key of each host's auth subkey."},
+			values:   sshKeys,
+		},
+		{
+			name:     "gpg-ssh-keygrips.nix",
+			comments: []string{generated, "Keygrip of each host's auth subkey for gpg-agent sshcontrol."},
+			values:   keygrips,
+		},
+		{
+			name:     "gpg-signing-keys.nix",
+			comments: []string{generated, "Signing subkey fingerprint of each host, pinned with !."},
+			values:   signing,
+		},
+	}, nil
+}
+
+// hostCapabilitySubkeys returns the host's auth-capable and signing-capable
+// subkeys according to the keyring. It fails when a host references more than
+// one subkey with the same capability.
+func hostCapabilitySubkeys(cfg *config.Config, hostName string) (auth, sign *gpg.KeyInfo, err error) {
+	host, err := cfg.GetHost(hostName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var authRef, signRef string
+	for _, ref := range host.Refs() {
+		resolved, err := cfg.ResolveRef(ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resolved.Fingerprint == "" {
+			continue
+		}
+
+		infos, err := gpg.ListKeys(false, resolved.ParentFP)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list key for %s: %w", ref, err)
+		}
+		if len(infos) == 0 {
+			return nil, nil, fmt.Errorf("key for %s not found in keyring", ref)
+		}
+		sub := infos[0].Find(resolved.Fingerprint)
+		if sub == nil {
+			return nil, nil, fmt.Errorf("subkey %s for %s not found in keyring", resolved.Fingerprint, ref)
+		}
+
+		caps := strings.ToLower(sub.Capabilities)
+		if strings.Contains(caps, "a") {
+			if auth != nil {
+				return nil, nil, &config.ConfigError{Msg: fmt.Sprintf("hosts.%s references more than one auth subkey: %s and %s", hostName, authRef, ref)}
+			}
+			auth, authRef = sub, ref
+		}
+		if strings.Contains(caps, "s") {
+			if sign != nil {
+				return nil, nil, &config.ConfigError{Msg: fmt.Sprintf("hosts.%s references more than one signing subkey: %s and %s", hostName, signRef, ref)}
+			}
+			sign, signRef = sub, ref
+		}
+	}
+
+	return auth, sign, nil
+}
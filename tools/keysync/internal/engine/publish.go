@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/hkp"
+	"github.com/OnTheWehn333/keysync/internal/op"
+)
+
+// PublishOpts controls keyserver publishing.
+type PublishOpts struct {
+	KeyName string
+	// Keyserver overrides the config's keyserver.
+	Keyserver string
+	// Client overrides the HKP client built from the keyserver URL.
+	Client *hkp.Client
+	// Verify fetches the published key back and compares it with the key's
+	// sha256_public.
+	Verify bool
+	// CheckOnly skips the upload and only verifies.
+	CheckOnly bool
+}
+
+// Publish uploads a key's public key to an HKP keyserver and, when asked,
+// confirms the published copy matches the sha256_public of its backup item.
+func Publish(cfg *config.Config, opts PublishOpts) error {
+	key, err := cfg.GetKey(opts.KeyName)
+	if err != nil {
+		return err
+	}
+	if key.Pending() {
+		return &config.ConfigError{Msg: fmt.Sprintf("%s has not been generated yet; run keysync generate --key %s", opts.KeyName, opts.KeyName)}
+	}
+
+	client := opts.Client
+	if client == nil {
+		keyserver := defaultString(opts.Keyserver, defaultString(cfg.Keyserver, hkp.DefaultKeyserver))
+		client, err = hkp.NewClient(keyserver)
+		if err != nil {
+			return &config.ConfigError{Msg: err.Error()}
+		}
+	}
+
+	if !opts.CheckOnly {
+		pubKey, err := gpg.ExportPublicKey(key.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("failed to export public key for %s: %w", opts.KeyName, err)
+		}
+		if err := client.Add(pubKey); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", opts.KeyName, err)
+		}
+		fmt.Printf("+ %s published to %s\n", opts.KeyName, client.BaseURL)
+	}
+
+	if !opts.Verify && !opts.CheckOnly {
+		return nil
+	}
+
+	vault := keyVault(cfg, opts.KeyName)
+	if err := op.EnsureSignedIn(vault.Account); err != nil {
+		return err
+	}
+	item, err := op.GetItem(key.Title, vault)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s from 1Password: %w", key.Title, err)
+	}
+	if item == nil || item.FieldValue("sha256_public") == "" {
+		return fmt.Errorf("1Password item %q has no sha256_public; run keysync backup --key %s", key.Title, opts.KeyName)
+	}
+	return verifyPublished(opts.KeyName, key, item, client)
+}
+
+// verifyPublished fetches a key from the keyserver and compares it with the
+// sha256_public of its backup item. Keyservers such as keys.openpgp.org strip
+// unverified user IDs and third-party signatures, so a copy whose hash
+// differs is still accepted when its fingerprints, expiry and revocation
+// state match the backed-up public key.
+func verifyPublished(keyName string, key *config.Key, item *op.Item, client *hkp.Client) error {
+	fetched, err := client.Lookup(key.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to fetch published %s: %w", keyName, err)
+	}
+	if fetched == nil {
+		return fmt.Errorf("%s is not published on %s", keyName, client.BaseURL)
+	}
+
+	// gpg cannot import a copy stripped of every user ID, which leaves only
+	// the state comparison.
+	stored := item.FieldValue("sha256_public")
+	published := ""
+	if normalized, err := gpg.NormalizePublicKey(fetched, key.Fingerprint); err == nil {
+		published = sha256Hex(normalized)
+	}
+	if published == stored {
+		fmt.Printf("= %s published copy matches sha256_public\n", keyName)
+		return nil
+	}
+
+	if err := comparePublished(key.Fingerprint, []byte(item.FieldValue("public_key")), fetched); err != nil {
+		return fmt.Errorf("published copy of %s does not match sha256_public (stored: %s, published: %s): %w", keyName, stored, defaultString(published, "unreadable"), err)
+	}
+	fmt.Printf("~ %s published copy differs from sha256_public, likely stripped by the keyserver; fingerprints, expiry and revocation state match\n", keyName)
+	return nil
+}
+
+// comparePublished checks that a published key has the same primary and
+// subkeys as the backed-up one, each with the same expiry and revocation
+// state. The primary's expiry is carried by its user ID signatures, so it is
+// only compared when the published copy kept a user ID.
+func comparePublished(fingerprint string, backedUp, published []byte) error {
+	want, err := showKey(fingerprint, backedUp)
+	if err != nil {
+		return fmt.Errorf("backed-up public_key: %w", err)
+	}
+	got, err := showKey(fingerprint, published)
+	if err != nil {
+		return fmt.Errorf("published key: %w", err)
+	}
+
+	if len(got.Subkeys) != len(want.Subkeys) {
+		return fmt.Errorf("published key has %d subkeys, want %d", len(got.Subkeys), len(want.Subkeys))
+	}
+	if (got.Validity == "r") != (want.Validity == "r") {
+		return fmt.Errorf("revocation state of %s differs", fingerprint)
+	}
+	if len(got.UIDs) > 0 && got.Expires != want.Expires {
+		return fmt.Errorf("expiry of %s differs", fingerprint)
+	}
+	for _, sub := range want.Subkeys {
+		other := got.Find(sub.Fingerprint)
+		switch {
+		case other == nil:
+			return fmt.Errorf("published key lacks subkey %s", sub.Fingerprint)
+		case (other.Validity == "r") != (sub.Validity == "r"):
+			return fmt.Errorf("revocation state of subkey %s differs", sub.Fingerprint)
+		case other.Expires != sub.Expires:
+			return fmt.Errorf("expiry of subkey %s differs", sub.Fingerprint)
+		}
+	}
+	return nil
+}
+
+func showKey(fingerprint string, armored []byte) (*gpg.KeyInfo, error) {
+	infos, err := gpg.ShowKeys(armored)
+	if err != nil {
+		return nil, err
+	}
+	if info := findKeyInfo(infos, fingerprint); info != nil {
+		return info, nil
+	}
+	return nil, fmt.Errorf("does not contain %s", fingerprint)
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/hkp"
+	"github.com/OnTheWehn333/keysync/internal/op"
+)
+
+// testGPG runs gpg in an isolated home and returns its output.
+func testGPG(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("gpg", append([]string{"--batch", "--yes", "--no-tty", "--passphrase", "", "--pinentry-mode", "loopback"}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("gpg %s: %s: %v", strings.Join(args, " "), stderr.String(), err)
+	}
+	return stdout.String()
+}
+
+// testKey creates a primary with two user IDs and an encryption subkey in a
+// temporary GNUPGHOME and returns the primary and subkey fingerprints.
+func testKey(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	home, err := os.MkdirTemp("", "keysync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--homedir", home, "--kill", "all").Run()
+		os.RemoveAll(home)
+	})
+
+	testGPG(t, "--quick-gen-key", "Test <test@example.org>", "ed25519", "cert", "1y")
+	infos, err := gpg.ListKeys(false)
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("ListKeys = %v, %v", infos, err)
+	}
+	fp := infos[0].Fingerprint
+	testGPG(t, "--quick-add-uid", fp, "Other <other@example.org>")
+	testGPG(t, "--quick-add-key", fp, "cv25519", "encr", "1y")
+
+	infos, err = gpg.ListKeys(false, fp)
+	if err != nil || len(infos) != 1 || len(infos[0].Subkeys) != 1 {
+		t.Fatalf("ListKeys(%s) = %v, %v", fp, infos, err)
+	}
+	return fp, infos[0].Subkeys[0].Fingerprint
+}
+
+// backupItem returns a backup item recording the key as it is now.
+func backupItem(t *testing.T, fp string) *op.Item {
+	t.Helper()
+	pub, err := gpg.ExportPublicKey(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &op.Item{Fields: []op.Field{
+		{Label: "public_key", Value: string(pub)},
+		{Label: "sha256_public", Value: sha256Hex(pub)},
+	}}
+}
+
+func keyserver(t *testing.T, published []byte) *hkp.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if published == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(published)
+	}))
+	t.Cleanup(server.Close)
+	return &hkp.Client{BaseURL: server.URL, HTTPClient: server.Client()}
+}
+
+func TestVerifyPublished(t *testing.T) {
+	fp, subFP := testKey(t)
+	key := &config.Key{Fingerprint: fp}
+	item := backupItem(t, fp)
+
+	full := []byte(testGPG(t, "--armor", "--export", fp))
+	stripped := []byte(testGPG(t, "--armor", "--export-filter", "keep-uid=mbox=test@example.org", "--export", fp))
+	noUIDs := []byte(testGPG(t, "--armor", "--export-filter", "keep-uid=mbox=nobody@example.org", "--export", fp))
+	testGPG(t, "--quick-set-expire", fp, "2y", subFP)
+	extended := []byte(testGPG(t, "--armor", "--export", fp))
+	cert, err := os.ReadFile(filepath.Join(os.Getenv("GNUPGHOME"), "openpgp-revocs.d", fp+".rev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocation := filepath.Join(t.TempDir(), "revocation.asc")
+	if err := os.WriteFile(revocation, bytes.ReplaceAll(cert, []byte(":-----"), []byte("-----")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	testGPG(t, "--import", revocation)
+	revoked := []byte(testGPG(t, "--armor", "--export", fp))
+
+	tests := []struct {
+		name      string
+		published []byte
+		wantErr   string
+	}{
+		{name: "identical", published: full},
+		{name: "user ID stripped", published: stripped},
+		{name: "every user ID stripped", published: noUIDs},
+		{name: "subkey expiry changed", published: extended, wantErr: "expiry of subkey"},
+		{name: "revoked", published: revoked, wantErr: "revocation state"},
+		{name: "not published", published: nil, wantErr: "is not published"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPublished("test", key, item, keyserver(t, tt.published))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyPublished: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("verifyPublished error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
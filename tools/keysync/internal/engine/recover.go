@@ -0,0 +1,248 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/op"
+)
+
+// RecoverOpts controls rebuilding a config from the vault.
+type RecoverOpts struct {
+	Vault   string
+	Account string
+	// Host names the stub host that references every recovered subkey
+	// (default: this machine's host name).
+	Host   string
+	DryRun bool
+	Force  bool
+}
+
+// recoveredKey collects the items found for one key title.
+type recoveredKey struct {
+	key *config.Key
+	// info is the primary key parsed from a stored public key, used to name
+	// subkeys when only the backup item survived.
+	info *gpg.KeyInfo
+}
+
+// RecoverConfig rebuilds the keys section of a config from the items tagged
+// keysync in a vault. Backup items give each key's primary fingerprint and
+// items titled "<key title>/<subkey>" its subkeys; both are checked against the
+// stored public key. Hosts with per-host items are recovered with their
+// references and passphrase policy; the rest are left as one stub host that
+// references every subkey.
+func RecoverConfig(cfgPath string, opts RecoverOpts) error {
+	if !opts.DryRun && !opts.Force {
+		if _, err := os.Stat(cfgPath); err == nil {
+			return &config.ConfigError{Msg: fmt.Sprintf("%s already exists; use --force to overwrite it or --dry-run to print the result", cfgPath)}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot read %s: %w", cfgPath, err)
+		}
+	}
+	if !opts.DryRun {
+		if err := config.CanWrite(cfgPath); err != nil {
+			return err
+		}
+	}
+
+	vault := op.Vault{Name: opts.Vault, Account: opts.Account}
+	if err := op.EnsureSignedIn(vault.Account); err != nil {
+		return err
+	}
+
+	items, err := op.ListItems(vault, "keysync")
+	if err != nil {
+		return err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Title < items[j].Title })
+
+	// Progress goes to stderr when the config itself is printed.
+	out := os.Stdout
+	if opts.DryRun {
+		out = os.Stderr
+	}
+
+	keys := make(map[string]*recoveredKey)
+	hosts := make(map[string]*config.Host)
+	var failures int
+
+	for _, summary := range items {
+		item, err := op.GetItem(summary.ID, vault)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s from 1Password: %w", summary.Title, err)
+		}
+		if item == nil {
+			continue
+		}
+
+		fp := strings.ToUpper(item.FieldValue("fingerprint"))
+		publicKey := item.FieldValue("public_key")
+		if fp == "" || publicKey == "" {
+			fmt.Fprintf(out, "~ %s has no fingerprint or public_key; skipped\n", item.Title)
+			continue
+		}
+
+		infos, err := gpg.ShowKeys([]byte(publicKey))
+		if err != nil {
+			return fmt.Errorf("failed to read public_key of %q: %w", item.Title, err)
+		}
+		var info *gpg.KeyInfo
+		for _, candidate := range infos {
+			if candidate.Find(fp) != nil {
+				info = candidate
+				break
+			}
+		}
+		if info == nil {
+			fmt.Fprintf(out, "! %s public_key does not contain %s\n", item.Title, fp)
+			failures++
+			continue
+		}
+		primaryFP := strings.ToUpper(info.Fingerprint)
+
+		title, hostName := item.Title, ""
+		if i := strings.LastIndex(title, "@"); i > strings.LastIndex(title, "/") {
+			title, hostName = title[:i], title[i+1:]
+		}
+
+		keyTitle, subName := title, ""
+		if fp != primaryFP {
+			i := strings.LastIndex(title, "/")
+			if i < 0 {
+				fmt.Fprintf(out, "! %s holds subkey %s but its title has no /<subkey> suffix\n", item.Title, fp)
+				failures++
+				continue
+			}
+			keyTitle, subName = title[:i], title[i+1:]
+		}
+
+		rk := keys[keyTitle]
+		if rk == nil {
+			rk = &recoveredKey{
+				key: &config.Key{
+					Title:       keyTitle,
+					Fingerprint: primaryFP,
+					Subkeys:     make(map[string]*config.Subkey),
+				},
+				info: info,
+			}
+			keys[keyTitle] = rk
+		} else if rk.key.Fingerprint != primaryFP {
+			fmt.Fprintf(out, "! %s belongs to primary %s, but %s items belong to %s\n", item.Title, primaryFP, keyTitle, rk.key.Fingerprint)
+			failures++
+			continue
+		}
+
+		if subName == "" {
+			continue
+		}
+		if sub := rk.key.Subkeys[subName]; sub != nil && sub.Fingerprint != fp {
+			fmt.Fprintf(out, "! %s is %s, but another item has %s\n", item.Title, fp, sub.Fingerprint)
+			failures++
+			continue
+		}
+		rk.key.Subkeys[subName] = &config.Subkey{Fingerprint: fp}
+
+		if hostName != "" {
+			host := hosts[hostName]
+			if host == nil {
+				host = &config.Host{PassphrasePolicy: item.FieldValue("passphrase_policy")}
+				hosts[hostName] = host
+			}
+			host.Keys = append(host.Keys, path.Base(keyTitle)+"."+subName)
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d items in vault %s are inconsistent", failures, vault)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no items tagged keysync in vault %s", vault)
+	}
+
+	cfg := &config.Config{
+		Version: 1,
+		Vault:   opts.Vault,
+		Account: opts.Account,
+		Keys:    make(map[string]*config.Key),
+		Hosts:   make(map[string]*config.Host),
+	}
+
+	titles := make([]string, 0, len(keys))
+	for title := range keys {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	for _, title := range titles {
+		rk := keys[title]
+		name := path.Base(title)
+		if other, ok := cfg.Keys[name]; ok {
+			return fmt.Errorf("items %q and %q would both be named %q", other.Title, title, name)
+		}
+		if len(rk.key.Subkeys) == 0 {
+			for _, sub := range rk.info.Subkeys {
+				if !usableKey(sub) {
+					continue
+				}
+				subName := uniqueName(rk.key.Subkeys, subkeyName(sub.Capabilities))
+				rk.key.Subkeys[subName] = &config.Subkey{Fingerprint: strings.ToUpper(sub.Fingerprint)}
+			}
+			fmt.Fprintf(out, "~ %s has no subkey items; subkeys named by capability\n", name)
+		}
+		cfg.Keys[name] = rk.key
+		fmt.Fprintf(out, "+ %s %s\n", name, rk.key.Fingerprint)
+	}
+
+	hostNames := make([]string, 0, len(hosts))
+	for hostName := range hosts {
+		hostNames = append(hostNames, hostName)
+	}
+	sort.Strings(hostNames)
+	for _, hostName := range hostNames {
+		host := hosts[hostName]
+		sort.Strings(host.Keys)
+		cfg.Hosts[hostName] = host
+		fmt.Fprintf(out, "+ host %s (%s)\n", hostName, host.Policy())
+	}
+
+	stubName := opts.Host
+	if stubName == "" {
+		stubName, _ = os.Hostname()
+	}
+	if _, ok := cfg.Hosts[stubName]; !ok {
+		stub := &config.Host{}
+		for _, name := range cfg.AllKeyNames() {
+			for _, subName := range cfg.Keys[name].SubkeyNames() {
+				stub.Keys = append(stub.Keys, name+"."+subName)
+			}
+		}
+		cfg.Hosts[stubName] = stub
+		fmt.Fprintf(out, "~ host %s is a stub referencing every subkey; edit it before syncing\n", stubName)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		data, err := cfg.Marshal()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if err := cfg.Save(cfgPath); err != nil {
+		return err
+	}
+	fmt.Printf("+ %s created\n", cfgPath)
+	return nil
+}
@@ -0,0 +1,311 @@
+package engine
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/git"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/sops"
+)
+
+// SopsRekeyOpts controls re-encryption of sops files after key changes.
+type SopsRekeyOpts struct {
+	// From is the previous config file. When empty, the config file at Rev
+	// in git history is used instead.
+	From   string
+	Rev    string
+	DryRun bool
+}
+
+// sopsFile is one sops-encrypted file under the config directory.
+type sopsFile struct {
+	path      string
+	rel       string
+	current   []string
+	intended  []string
+	previous  []string
+	unmatched bool
+}
+
+// RekeySops finds the sops files whose recipients change between the previous
+// and current config, or that no longer match their creation rule, prints the
+// recipient diff for each, and unless DryRun is set rewrites .sops.yaml, runs
+// sops updatekeys on every affected file and verifies the result.
+func RekeySops(cfg *config.Config, cfgPath, sopsPath string, opts SopsRekeyOpts) error {
+	rules, err := sopsRules(cfg)
+	if err != nil {
+		return err
+	}
+
+	prev, err := previousConfig(cfgPath, opts)
+	if err != nil {
+		return err
+	}
+	var prevRules []sops.Rule
+	if prev.Sops != nil && len(prev.Sops.Rules) > 0 {
+		if prevRules, err = sopsRules(prev); err != nil {
+			return fmt.Errorf("previous config: %w", err)
+		}
+	}
+
+	files, err := findSopsFiles(filepath.Dir(cfgPath), rules, prevRules)
+	if err != nil {
+		return err
+	}
+
+	labels := recipientLabels(prev)
+	for fp, label := range recipientLabels(cfg) {
+		labels[fp] = label
+	}
+	var affected []*sopsFile
+	for _, file := range files {
+		if file.unmatched {
+			fmt.Printf("~ %s matches no creation rule\n", file.rel)
+			continue
+		}
+		if sameStrings(file.current, file.intended) && sameStrings(file.previous, file.intended) {
+			fmt.Printf("= %s unchanged\n", file.rel)
+			continue
+		}
+		affected = append(affected, file)
+		fmt.Printf("~ %s recipients:\n", file.rel)
+		printRecipientDiff(file.current, file.intended, labels)
+	}
+
+	if opts.DryRun || len(affected) == 0 {
+		return nil
+	}
+
+	if err := GenerateSops(cfg, sopsPath); err != nil {
+		return err
+	}
+
+	for _, file := range affected {
+		if err := sops.UpdateKeys(sopsPath, file.path); err != nil {
+			return fmt.Errorf("failed to rekey %s: %w", file.rel, err)
+		}
+		fmt.Printf("- %s rekeyed\n", file.rel)
+	}
+
+	return verifySopsFiles(affected, labels)
+}
+
+// previousConfig loads the config to compare against: opts.From, or the
+// config file and the files it includes as of opts.Rev.
+func previousConfig(cfgPath string, opts SopsRekeyOpts) (*config.Config, error) {
+	var prev *config.Config
+	var err error
+	if opts.From != "" {
+		prev, err = config.Load(opts.From)
+	} else {
+		rev := defaultString(opts.Rev, "HEAD")
+		if prev, err = config.LoadFiles(cfgPath, git.Revision{Rev: rev}); err != nil {
+			err = fmt.Errorf("%s at %s: %w", cfgPath, rev, err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("previous config: %w", err)
+	}
+	return prev, nil
+}
+
+// findSopsFiles walks root for YAML and JSON files carrying sops metadata and
+// resolves their recipients under the current and previous rules. Without
+// previous rules, a file's previous recipients are its current ones.
+func findSopsFiles(root string, rules, prevRules []sops.Rule) ([]*sopsFile, error) {
+	var files []*sopsFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+
+		current, ok, err := sops.Recipients(path)
+		if err != nil || !ok {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			rel = path
+		}
+		rel = filepath.ToSlash(rel)
+
+		file := &sopsFile{path: path, rel: rel, current: current, previous: current}
+		intended, ok, err := matchSopsRule(rules, rel)
+		if err != nil {
+			return err
+		}
+		file.intended = intended
+		file.unmatched = !ok
+		if prevRules != nil {
+			if previous, ok, err := matchSopsRule(prevRules, rel); err != nil {
+				return err
+			} else if ok {
+				file.previous = previous
+			}
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s for sops files: %w", root, err)
+	}
+	return files, nil
+}
+
+// matchSopsRule returns the sorted, upper-cased recipients of the first rule
+// whose path_regex matches rel, as sops itself picks creation rules.
+func matchSopsRule(rules []sops.Rule, rel string) ([]string, bool, error) {
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.PathRegex)
+		if err != nil {
+			return nil, false, &config.ConfigError{Msg: fmt.Sprintf("invalid sops path_regex %q: %v", rule.PathRegex, err)}
+		}
+		if !re.MatchString(rel) {
+			continue
+		}
+		fps := make([]string, 0, len(rule.PGP))
+		for _, fp := range rule.PGP {
+			fps = append(fps, strings.ToUpper(fp))
+		}
+		sort.Strings(fps)
+		return fps, true, nil
+	}
+	return nil, false, nil
+}
+
+// recipientLabels maps key fingerprints to the host that owns the key, or to
+// the key name for keys no single host owns.
+func recipientLabels(cfg *config.Config) map[string]string {
+	labels := make(map[string]string)
+	for _, keyName := range cfg.AllKeyNames() {
+		if key := cfg.Keys[keyName]; !key.Pending() {
+			labels[strings.ToUpper(key.Fingerprint)] = keyName
+		}
+	}
+	for _, hostName := range cfg.AllHostNames() {
+		for _, keyName := range cfg.OwnedKeys(hostName) {
+			if key := cfg.Keys[keyName]; !key.Pending() {
+				labels[strings.ToUpper(key.Fingerprint)] = hostName
+			}
+		}
+	}
+	return labels
+}
+
+func printRecipientDiff(current, intended []string, labels map[string]string) {
+	for _, fp := range current {
+		if !containsString(intended, fp) {
+			fmt.Printf("    - %s\n", recipientLabel(fp, labels))
+		}
+	}
+	for _, fp := range intended {
+		if !containsString(current, fp) {
+			fmt.Printf("    + %s\n", recipientLabel(fp, labels))
+		}
+	}
+	for _, fp := range intended {
+		if containsString(current, fp) {
+			fmt.Printf("      %s\n", recipientLabel(fp, labels))
+		}
+	}
+}
+
+func recipientLabel(fp string, labels map[string]string) string {
+	if label, ok := labels[fp]; ok {
+		return fp + " (" + label + ")"
+	}
+	return fp
+}
+
+// verifySopsFiles checks that each file is encrypted to exactly its intended
+// recipients and decrypts it when the local keyring holds the secret material
+// of any of them. Files no local key can open are only checked for their
+// recipients, and reported as such.
+func verifySopsFiles(files []*sopsFile, labels map[string]string) error {
+	hasSecret := make(map[string]bool)
+	var failures []string
+	for _, file := range files {
+		current, _, err := sops.Recipients(file.path)
+		if err != nil {
+			return err
+		}
+		if !sameStrings(current, file.intended) {
+			msg := fmt.Sprintf("%s is encrypted to %s, want %s", file.rel, strings.Join(current, ","), strings.Join(file.intended, ","))
+			failures = append(failures, msg)
+			fmt.Printf("! %s\n", msg)
+			continue
+		}
+
+		local := false
+		var names []string
+		for _, fp := range file.intended {
+			names = append(names, defaultString(labels[fp], fp))
+			if _, ok := hasSecret[fp]; !ok {
+				hasSecret[fp] = localSecret(fp)
+			}
+			local = local || hasSecret[fp]
+		}
+
+		if !local {
+			fmt.Printf("~ %s encrypted to %s; no local secret key to test decryption\n", file.rel, strings.Join(names, ", "))
+			continue
+		}
+		if err := sops.Decrypt(file.path); err != nil {
+			msg := fmt.Sprintf("%s: %v", file.rel, err)
+			failures = append(failures, msg)
+			fmt.Printf("! %s\n", msg)
+			continue
+		}
+		fmt.Printf("= %s decrypts; encrypted to %s\n", file.rel, strings.Join(names, ", "))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("rekey verification failures: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// localSecret reports whether the keyring holds the secret material of the
+// key or subkey fp, not just a stub for it.
+func localSecret(fp string) bool {
+	infos, err := gpg.ListKeys(true, fp)
+	if err != nil {
+		return false
+	}
+	for _, info := range infos {
+		if key := info.Find(fp); key != nil {
+			return key.HasSecret
+		}
+	}
+	return false
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/OnTheWehn333/keysync/internal/config"
 	"github.com/OnTheWehn333/keysync/internal/gpg"
@@ -13,31 +14,47 @@ type RestoreOpts struct {
 	DryRun     bool
 	Force      bool
 	VerifyHash bool
+	// PresetPassphrase caches the stored passphrase in gpg-agent after import.
+	PresetPassphrase bool
+	// SSH adds restored auth subkeys to gpg-agent's sshcontrol.
+	SSH     bool
+	SSHOpts SSHOpts
+	// WithPeers also imports the public keys of keys owned by other hosts.
+	WithPeers bool
 }
 
 // Restore restores all keys for a configured host from 1Password into the GPG keyring.
 func Restore(cfg *config.Config, hostName string, opts RestoreOpts) error {
-	host, err := cfg.GetHost(hostName)
+	refs, err := hostRefsByAccount(cfg, hostName)
 	if err != nil {
 		return err
 	}
-
-	if err := op.EnsureSignedIn(); err != nil {
+	locs := make([]config.Location, 0, len(refs))
+	for _, resolved := range refs {
+		locs = append(locs, resolved.Location)
+	}
+	for _, keyName := range cfg.PeerKeys(hostName, opts.WithPeers) {
+		locs = append(locs, cfg.KeyLocation(keyName))
+	}
+	if err := op.EnsureSignedIn(config.Accounts(locs...)...); err != nil {
 		return err
 	}
 
-	for _, ref := range host.Keys {
-		resolved, err := cfg.ResolveRef(ref)
-		if err != nil {
-			return err
+	// Several subkeys of one primary share its owner-trust.
+	trusted := make(map[string]bool)
+	for _, resolved := range refs {
+		ref := resolved.KeyName + "." + resolved.SubkeyName
+		if resolved.Fingerprint == "" {
+			return &config.ConfigError{Msg: fmt.Sprintf("%s has not been generated yet; run keysync generate --key %s", ref, resolved.KeyName)}
 		}
 
-		item, err := op.GetItem(resolved.ItemTitle, cfg.Vault)
+		vault := opVault(resolved.Location)
+		item, err := op.GetItem(resolved.ItemTitle, vault)
 		if err != nil {
 			return fmt.Errorf("failed to fetch %s from 1Password: %w", resolved.ItemTitle, err)
 		}
 		if item == nil {
-			return fmt.Errorf("1Password item %q not found in vault %q", resolved.ItemTitle, cfg.Vault)
+			return fmt.Errorf("1Password item %q not found in vault %s", resolved.ItemTitle, vault)
 		}
 
 		publicKey := item.FieldValue("public_key")
@@ -74,16 +91,189 @@ func Restore(cfg *config.Config, hostName string, opts RestoreOpts) error {
 			}
 		}
 
-		if err := gpg.ImportKey([]byte(publicKey)); err != nil {
+		// Items synced before a move to the none policy may still hold the
+		// old passphrase.
+		passphrase := item.FieldValue("passphrase")
+		if item.FieldValue("passphrase_policy") == config.PassphraseNone {
+			passphrase = ""
+		}
+
+		if err := gpg.ImportKey([]byte(publicKey), ""); err != nil {
 			return fmt.Errorf("failed to import public_key for %q: %w", resolved.ItemTitle, err)
 		}
 
-		if err := gpg.ImportKey([]byte(secretKey)); err != nil {
+		if err := gpg.ImportKey([]byte(secretKey), passphrase); err != nil {
 			return fmt.Errorf("failed to import secret_key for %q: %w", resolved.ItemTitle, err)
 		}
 
 		fmt.Printf("restored %s -> GPG keyring\n", resolved.ItemTitle)
+
+		if opts.PresetPassphrase {
+			if err := presetPassphrase(resolved.ParentFP, resolved.Fingerprint, passphrase); err != nil {
+				return err
+			}
+		}
+
+		if trusted[resolved.KeyName] {
+			continue
+		}
+		trusted[resolved.KeyName] = true
+		key, _ := cfg.GetKey(resolved.KeyName)
+		if err := restoreOwnertrust(resolved.KeyName, key, item); err != nil {
+			return err
+		}
 	}
 
+	if err := restorePeers(cfg, hostName, opts); err != nil {
+		return err
+	}
+
+	if opts.SSH && !opts.DryRun {
+		return EnableSSH(cfg, hostName, opts.SSHOpts)
+	}
+
+	return nil
+}
+
+// restorePeers imports only the public part of the host's peer keys, read
+// from each key's backup item or, failing that, one of its subkey items.
+func restorePeers(cfg *config.Config, hostName string, opts RestoreOpts) error {
+	peers := cfg.PeerKeys(hostName, opts.WithPeers)
+	if len(peers) == 0 {
+		return nil
+	}
+
+	fmt.Printf("\npeer public keys:\n")
+	for _, keyName := range peers {
+		key := cfg.Keys[keyName]
+		if key.Pending() {
+			fmt.Printf("~ %s skipped (not generated yet)\n", keyName)
+			continue
+		}
+
+		title, publicKey, err := peerPublicKey(cfg, keyName, key)
+		if err != nil {
+			return err
+		}
+		if publicKey == "" {
+			return fmt.Errorf("no 1Password item holds a public_key for %s", keyName)
+		}
+
+		if err := checkPublicOnly(title, key.Fingerprint, publicKey); err != nil {
+			return err
+		}
+
+		if opts.DryRun {
+			fmt.Printf("would restore public %s -> GPG keyring\n", title)
+			continue
+		}
+
+		if err := gpg.ImportKey([]byte(publicKey), ""); err != nil {
+			return fmt.Errorf("failed to import public_key for %q: %w", title, err)
+		}
+
+		fmt.Printf("restored public %s -> GPG keyring\n", title)
+	}
+	return nil
+}
+
+// checkPublicOnly makes sure a peer's public_key holds the expected primary
+// and no secret material, which a peer host must never receive.
+func checkPublicOnly(title, fingerprint, publicKey string) error {
+	infos, err := gpg.ShowKeys([]byte(publicKey))
+	if err != nil {
+		return fmt.Errorf("invalid public_key in %q: %w", title, err)
+	}
+	found := false
+	for _, info := range infos {
+		found = found || strings.EqualFold(info.Fingerprint, fingerprint)
+		for _, k := range append([]*gpg.KeyInfo{info}, info.Subkeys...) {
+			if k.HasSecret {
+				return fmt.Errorf("public_key in %q contains secret key material for %s", title, k.Fingerprint)
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("public_key in %q does not contain %s", title, fingerprint)
+	}
+	return nil
+}
+
+func peerPublicKey(cfg *config.Config, keyName string, key *config.Key) (string, string, error) {
+	titles := []string{key.Title}
+	vaults := []op.Vault{keyVault(cfg, keyName)}
+	for _, subName := range key.SubkeyNames() {
+		resolved, err := cfg.ResolveRef(keyName + "." + subName)
+		if err != nil {
+			return "", "", err
+		}
+		titles = append(titles, resolved.ItemTitle)
+		vaults = append(vaults, opVault(resolved.Location))
+	}
+
+	for i, title := range titles {
+		item, err := op.GetItem(title, vaults[i])
+		if err != nil {
+			return "", "", fmt.Errorf("failed to fetch %s from 1Password: %w", title, err)
+		}
+		if item != nil && item.FieldValue("public_key") != "" {
+			return title, item.FieldValue("public_key"), nil
+		}
+	}
+	return "", "", nil
+}
+
+// presetPassphrase caches passphrase in gpg-agent for the keygrip of one
+// subkey, or of the primary and every subkey when subkeyFP is empty.
+func presetPassphrase(primaryFP, subkeyFP, passphrase string) error {
+	if passphrase == "" {
+		return nil
+	}
+
+	infos, err := gpg.ListKeys(true, primaryFP)
+	if err != nil {
+		return fmt.Errorf("failed to read keygrips for %s: %w", primaryFP, err)
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("no secret key %s in keyring after import", primaryFP)
+	}
+
+	var targets []*gpg.KeyInfo
+	if subkeyFP != "" {
+		if sub := infos[0].Find(subkeyFP); sub != nil {
+			targets = append(targets, sub)
+		}
+	} else {
+		targets = append(targets, infos[0])
+		targets = append(targets, infos[0].Subkeys...)
+	}
+
+	for _, info := range targets {
+		if !info.HasSecret || info.Keygrip == "" {
+			continue
+		}
+		if err := gpg.PresetPassphrase(info.Keygrip, passphrase); err != nil {
+			return fmt.Errorf("failed to preset passphrase for %s: %w", info.Fingerprint, err)
+		}
+		fmt.Printf("preset passphrase %s -> gpg-agent\n", info.Fingerprint)
+	}
+	return nil
+}
+
+// restoreOwnertrust applies the owner-trust configured for a key, falling back
+// to the level recorded in its 1Password item.
+func restoreOwnertrust(keyName string, key *config.Key, item *op.Item) error {
+	trust := key.Trust
+	if trust == "" {
+		trust = item.FieldValue("ownertrust")
+	}
+	if trust == "" {
+		return nil
+	}
+
+	if err := gpg.ImportOwnertrust(key.Fingerprint, trust); err != nil {
+		return fmt.Errorf("failed to set owner-trust for %s: %w", keyName, err)
+	}
+	fmt.Printf("trusted %s -> %s\n", keyName, trust)
 	return nil
 }
@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+	"github.com/OnTheWehn333/keysync/internal/op"
+)
+
+// RevokeOpts controls key and subkey revocation.
+type RevokeOpts struct {
+	// KeyName revokes a whole top-level key; Ref revokes a single subkey.
+	KeyName     string
+	Ref         string
+	Reason      gpg.RevocationReason
+	Description string
+	// Output is where the revoked public key is written.
+	Output string
+}
+
+// Revoke revokes a top-level key or one subkey in the local keyring, re-syncs
+// the affected 1Password items, and exports the revoked public key.
+func Revoke(cfg *config.Config, opts RevokeOpts) error {
+	keyName := opts.KeyName
+	if opts.Ref != "" {
+		resolved, err := cfg.ResolveRef(opts.Ref)
+		if err != nil {
+			return err
+		}
+		keyName = resolved.KeyName
+	}
+
+	key, err := cfg.GetKey(keyName)
+	if err != nil {
+		return err
+	}
+	if key.Pending() {
+		return &config.ConfigError{Msg: fmt.Sprintf("%s has not been generated yet; nothing to revoke", keyName)}
+	}
+
+	// Every synced item carries the full public key, so all of them are stale.
+	targets, err := syncTargets(cfg, keyName)
+	if err != nil {
+		return err
+	}
+	locs := []config.Location{cfg.KeyLocation(keyName)}
+	for _, target := range targets {
+		locs = append(locs, target.resolved.Location)
+	}
+	if err := op.EnsureSignedIn(config.Accounts(locs...)...); err != nil {
+		return err
+	}
+
+	if opts.Ref != "" {
+		resolved, _ := cfg.ResolveRef(opts.Ref)
+		if err := gpg.RevokeSubkey(resolved.ParentFP, resolved.Fingerprint, opts.Reason, opts.Description); err != nil {
+			return fmt.Errorf("failed to revoke %s: %w", opts.Ref, err)
+		}
+		fmt.Printf("x %s revoked\n", opts.Ref)
+	} else {
+		cert, err := keyRevocationCert(cfg, keyName, key, opts)
+		if err != nil {
+			return err
+		}
+		if err := gpg.ImportKey(cert, ""); err != nil {
+			return fmt.Errorf("failed to apply revocation certificate for %s: %w", keyName, err)
+		}
+		fmt.Printf("x %s revoked\n", keyName)
+	}
+
+	if err := BackupKey(cfg, keyName); err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if err := syncResolved(cfg, target.resolved, target.policy); err != nil {
+			return err
+		}
+	}
+
+	pubKey, err := gpg.ExportPublicKey(key.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to export public key for %s: %w", keyName, err)
+	}
+
+	output := opts.Output
+	if output == "" {
+		output = keyName + ".revoked.asc"
+	}
+	if err := os.WriteFile(output, pubKey, 0o644); err != nil {
+		return fmt.Errorf("failed to write revoked public key: %w", err)
+	}
+	fmt.Printf("+ %s written\n", output)
+
+	return nil
+}
+
+// keyRevocationCert generates a certificate with the requested reason when the
+// primary secret key is available, and otherwise uses the certificate stored
+// with the key backup, which backup creates while the secret key is there.
+func keyRevocationCert(cfg *config.Config, keyName string, key *config.Key, opts RevokeOpts) ([]byte, error) {
+	secret, err := gpg.ListKeys(true, key.Fingerprint)
+	if err == nil && len(secret) > 0 && secret[0].HasSecret {
+		return gpg.GenerateRevocation(key.Fingerprint, opts.Reason, opts.Description)
+	}
+
+	item, err := op.GetItem(key.Title, keyVault(cfg, keyName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from 1Password: %w", key.Title, err)
+	}
+	if item == nil || item.FieldValue("revocation_cert") == "" {
+		return nil, fmt.Errorf("no primary secret key in the keyring and no revocation_cert stored in %q", key.Title)
+	}
+
+	fmt.Printf("~ using stored revocation certificate from %s; --reason is ignored\n", key.Title)
+	return []byte(item.FieldValue("revocation_cert")), nil
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/sops"
+)
+
+// GenerateSops writes the .sops.yaml creation rules derived from the config's
+// sops section to path. A file that holds anything else, such as age or
+// key_groups rules, stores settings or comments, keeps it: only the PGP
+// rules keysync manages are edited.
+func GenerateSops(cfg *config.Config, path string) error {
+	data, unmanaged, err := renderSops(cfg, path)
+	if err != nil {
+		return err
+	}
+	if unmanaged > 0 {
+		fmt.Printf("~ %s: creation rules not in the config kept: %d\n", path, unmanaged)
+	}
+	return writeGenerated(path, data)
+}
+
+// CheckSops reports an error when the creation rules in the .sops.yaml at
+// path differ from the ones GenerateSops would write. Formatting is ignored.
+func CheckSops(cfg *config.Config, path string) error {
+	data, _, err := renderSops(cfg, path)
+	if err != nil {
+		return err
+	}
+	rules, err := sops.ParseCreationRules(data)
+	if err != nil {
+		return err
+	}
+
+	existing, err := readSopsRules(path)
+	if err != nil {
+		return err
+	}
+
+	if !slices.EqualFunc(existing, rules, sops.Rule.Equal) {
+		fmt.Printf("! %s differs\n", path)
+		return fmt.Errorf("%s is out of date; run keysync sops generate", path)
+	}
+	fmt.Printf("= %s up to date\n", path)
+	return nil
+}
+
+// renderSops returns the .sops.yaml at path with the config's creation rules
+// and the number of rules in it the config does not manage. A missing file,
+// or one holding only managed rules, is rendered afresh; any other file is
+// edited in place and returned unchanged when its rules are already up to
+// date, so that its formatting is kept.
+func renderSops(cfg *config.Config, path string) ([]byte, int, error) {
+	rules, err := sopsRules(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	managed, err := sops.Managed(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if managed {
+		return sops.RenderCreationRules(rules), 0, nil
+	}
+
+	doc, err := config.OpenDocument(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := doc.Add("creation_rules"); err != nil {
+		return nil, 0, err
+	}
+	seq, err := doc.Get("creation_rules")
+	if err != nil {
+		return nil, 0, err
+	}
+	unmanaged, err := sops.EditCreationRules(seq, rules)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	edited, err := doc.Bytes()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	before, err := sops.ParseCreationRules(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	after, err := sops.ParseCreationRules(edited)
+	if err != nil {
+		return nil, 0, err
+	}
+	if slices.EqualFunc(before, after, sops.Rule.Equal) {
+		return data, unmanaged, nil
+	}
+	return edited, unmanaged, nil
+}
+
+// readSopsRules reads the creation rules of the .sops.yaml at path; a missing
+// file has none.
+func readSopsRules(path string) ([]sops.Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	rules, err := sops.ParseCreationRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func sopsRules(cfg *config.Config) ([]sops.Rule, error) {
+	if cfg.Sops == nil || len(cfg.Sops.Rules) == 0 {
+		return nil, &config.ConfigError{Msg: "sops.rules is not configured"}
+	}
+
+	rules := make([]sops.Rule, 0, len(cfg.Sops.Rules))
+	for i, rule := range cfg.Sops.Rules {
+		fps, err := cfg.SopsRecipients(rule)
+		if err != nil {
+			return nil, fmt.Errorf("sops.rules[%d]: %w", i, err)
+		}
+		rules = append(rules, sops.Rule{PathRegex: rule.PathRegex, PGP: fps})
+	}
+	return rules, nil
+}
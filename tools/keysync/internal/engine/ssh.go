@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+	"github.com/OnTheWehn333/keysync/internal/gpg"
+)
+
+// SSHOpts controls how auth subkeys are added to gpg-agent's sshcontrol.
+type SSHOpts struct {
+	// TTL is the passphrase cache TTL in seconds; 0 uses the agent default.
+	TTL     int
+	Confirm bool
+}
+
+// EnableSSH adds the keygrip of every auth-capable subkey referenced by a host
+// to gpg-agent's sshcontrol file. Subkeys without local secret material are
+// skipped.
+func EnableSSH(cfg *config.Config, hostName string, opts SSHOpts) error {
+	host, err := cfg.GetHost(hostName)
+	if err != nil {
+		return err
+	}
+
+	enabled := 0
+	for _, ref := range host.Refs() {
+		resolved, err := cfg.ResolveRef(ref)
+		if err != nil {
+			return err
+		}
+		if resolved.Fingerprint == "" {
+			continue
+		}
+
+		infos, err := gpg.ListKeys(true, resolved.ParentFP)
+		if err != nil || len(infos) == 0 {
+			continue
+		}
+		sub := infos[0].Find(resolved.Fingerprint)
+		if sub == nil || !sub.HasSecret || sub.Keygrip == "" || !strings.Contains(strings.ToLower(sub.Capabilities), "a") {
+			continue
+		}
+
+		changed, err := gpg.EnableSSHKey(sub.Keygrip, opts.TTL, opts.Confirm)
+		if err != nil {
+			return fmt.Errorf("failed to enable ssh for %s: %w", ref, err)
+		}
+		enabled++
+		if changed {
+			fmt.Printf("+ %s -> sshcontrol (%s)\n", ref, sub.Keygrip)
+		} else {
+			fmt.Printf("= %s already in sshcontrol\n", ref)
+		}
+	}
+
+	if enabled == 0 {
+		fmt.Printf("~ %s has no auth subkeys with secret material in the keyring\n", hostName)
+	}
+	return nil
+}
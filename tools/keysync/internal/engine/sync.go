@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"sort"
 	"strings"
@@ -11,6 +13,13 @@ import (
 	"github.com/OnTheWehn333/keysync/internal/op"
 )
 
+// syncTarget is one 1Password item written by sync.
+type syncTarget struct {
+	label    string
+	resolved *config.ResolvedRef
+	policy   string
+}
+
 // SyncHost syncs all key references for one host to 1Password.
 func SyncHost(cfg *config.Config, hostName string) error {
 	host, err := cfg.GetHost(hostName)
@@ -18,12 +27,16 @@ func SyncHost(cfg *config.Config, hostName string) error {
 		return err
 	}
 
-	if err := op.EnsureSignedIn(); err != nil {
+	resolved, err := hostRefsByAccount(cfg, hostName)
+	if err != nil {
+		return err
+	}
+	if err := op.EnsureSignedIn(refAccounts(resolved)...); err != nil {
 		return err
 	}
 
-	for _, ref := range host.Keys {
-		if err := syncRef(cfg, ref); err != nil {
+	for _, r := range resolved {
+		if err := syncResolved(cfg, r, host.Policy()); err != nil {
 			return err
 		}
 	}
@@ -31,29 +44,56 @@ func SyncHost(cfg *config.Config, hostName string) error {
 	return nil
 }
 
+// hostRefsByAccount resolves a host's references, grouped by the account of
+// their items so that op calls are batched per account.
+func hostRefsByAccount(cfg *config.Config, hostName string) ([]*config.ResolvedRef, error) {
+	host, err := cfg.GetHost(hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved []*config.ResolvedRef
+	for _, ref := range host.Refs() {
+		r, err := cfg.ResolveHostRef(hostName, ref)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, r)
+	}
+	sort.SliceStable(resolved, func(i, j int) bool {
+		return resolved[i].Location.Account < resolved[j].Location.Account
+	})
+	return resolved, nil
+}
+
+// refAccounts returns the accounts that resolved references need.
+func refAccounts(resolved []*config.ResolvedRef) []string {
+	locs := make([]config.Location, 0, len(resolved))
+	for _, r := range resolved {
+		locs = append(locs, r.Location)
+	}
+	return config.Accounts(locs...)
+}
+
 // SyncAll syncs all unique key references across all hosts to 1Password.
 func SyncAll(cfg *config.Config) error {
-	if err := op.EnsureSignedIn(); err != nil {
+	targets, err := syncTargets(cfg, "")
+	if err != nil {
 		return err
 	}
 
-	unique := make(map[string]struct{})
-	for _, host := range cfg.Hosts {
-		for _, ref := range host.Keys {
-			unique[ref] = struct{}{}
-		}
+	resolved := make([]*config.ResolvedRef, 0, len(targets))
+	for _, target := range targets {
+		resolved = append(resolved, target.resolved)
 	}
-
-	refs := make([]string, 0, len(unique))
-	for ref := range unique {
-		refs = append(refs, ref)
+	if err := op.EnsureSignedIn(refAccounts(resolved)...); err != nil {
+		return err
 	}
-	sort.Strings(refs)
 
 	var failures []string
-	for _, ref := range refs {
-		if err := syncRef(cfg, ref); err != nil {
-			msg := fmt.Sprintf("%s: %v", ref, err)
+	for _, target := range targets {
+		if err := syncResolved(cfg, target.resolved, target.policy); err != nil {
+			msg := fmt.Sprintf("%s: %v", target.label, err)
 			failures = append(failures, msg)
 			fmt.Printf("! %s\n", msg)
 		}
@@ -66,18 +106,84 @@ func SyncAll(cfg *config.Config) error {
 	return nil
 }
 
+// syncTargets returns one target per distinct item that hosts reference,
+// optionally limited to references under keyName, sorted by account, then
+// label. Shared references are synced once per vault; hosts with their own
+// passphrase policy get one target per reference.
+func syncTargets(cfg *config.Config, keyName string) ([]syncTarget, error) {
+	unique := make(map[string]syncTarget)
+	for _, hostName := range cfg.AllHostNames() {
+		host := cfg.Hosts[hostName]
+		for _, ref := range host.Refs() {
+			resolved, err := cfg.ResolveHostRef(hostName, ref)
+			if err != nil {
+				return nil, err
+			}
+			if keyName != "" && resolved.KeyName != keyName {
+				continue
+			}
+
+			label := ref
+			if resolved.Host != "" {
+				label += "@" + resolved.Host
+			} else if shared, _ := cfg.ResolveRef(ref); resolved.Location != shared.Location {
+				// A host's vault gives it its own copy of shared items.
+				label += " in " + opVault(resolved.Location).String()
+			}
+			unique[label] = syncTarget{label: label, resolved: resolved, policy: host.Policy()}
+		}
+	}
+
+	targets := make([]syncTarget, 0, len(unique))
+	for _, target := range unique {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		a, b := targets[i], targets[j]
+		if a.resolved.Location.Account != b.resolved.Location.Account {
+			return a.resolved.Location.Account < b.resolved.Location.Account
+		}
+		return a.label < b.label
+	})
+	return targets, nil
+}
+
 func syncRef(cfg *config.Config, ref string) error {
 	resolved, err := cfg.ResolveRef(ref)
 	if err != nil {
 		return err
 	}
+	return syncResolved(cfg, resolved, config.PassphraseInherit)
+}
+
+func syncResolved(cfg *config.Config, resolved *config.ResolvedRef, policy string) error {
+	ref := resolved.KeyName + "." + resolved.SubkeyName
+	if resolved.Fingerprint == "" {
+		return &config.ConfigError{Msg: fmt.Sprintf("%s has not been generated yet; run keysync generate --key %s", ref, resolved.KeyName)}
+	}
+
+	vault := opVault(resolved.Location)
+	existing, err := op.GetItem(resolved.ItemTitle, vault)
+	if err != nil {
+		return fmt.Errorf("failed to check 1Password item %q: %w", resolved.ItemTitle, err)
+	}
+
+	keyringItem := existing
+	if policy != config.PassphraseInherit {
+		// A per-host item stores the host passphrase, not the keyring's.
+		keyringItem = nil
+	}
+	passphrase, err := keyPassphrase(cfg, resolved.KeyName, keyringItem)
+	if err != nil {
+		return err
+	}
 
 	pubKey, err := gpg.ExportPublicKey(resolved.ParentFP)
 	if err != nil {
 		return fmt.Errorf("failed to export public key for %s: %w", ref, err)
 	}
 
-	secKey, err := gpg.ExportSecretSubkey(resolved.Fingerprint)
+	secKey, err := gpg.ExportSecretSubkey(resolved.Fingerprint, passphrase)
 	if err != nil {
 		return fmt.Errorf("failed to export secret subkey for %s: %w", ref, err)
 	}
@@ -87,45 +193,85 @@ func syncRef(cfg *config.Config, ref string) error {
 		return fmt.Errorf("failed to read key metadata for %s: %w", ref, err)
 	}
 
+	trust, err := gpg.ExportOwnertrust(resolved.ParentFP)
+	if err != nil {
+		return fmt.Errorf("failed to read owner-trust for %s: %w", ref, err)
+	}
+
 	pubHash := sha256Hex(pubKey)
-	secHash := sha256Hex(secKey)
 
-	existing, err := op.GetItem(resolved.ItemTitle, cfg.Vault)
-	if err != nil {
-		return fmt.Errorf("failed to check 1Password item %q: %w", resolved.ItemTitle, err)
+	storedPolicy := ""
+	if policy != config.PassphraseInherit {
+		storedPolicy = policy
+
+		// Re-protected exports differ on every run, so per-host items are
+		// compared by subkey, public key and policy instead of secret hash.
+		if existing != nil &&
+			existing.FieldValue("fingerprint") == resolved.Fingerprint &&
+			existing.FieldValue("sha256_public") == pubHash &&
+			existing.FieldValue("passphrase_policy") == policy &&
+			existing.FieldValue("ownertrust") == trust {
+			fmt.Printf("= %s unchanged\n", resolved.ItemTitle)
+			return nil
+		}
+
+		hostPassphrase := ""
+		if policy == config.PassphraseGenerate {
+			if existing != nil && existing.FieldValue("passphrase_policy") == policy {
+				hostPassphrase = existing.FieldValue("passphrase")
+			}
+			if hostPassphrase == "" {
+				hostPassphrase, err = generatePassphrase()
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		secKey, err = gpg.ReprotectSecretSubkey(pubKey, secKey, resolved.Fingerprint, passphrase, hostPassphrase)
+		if err != nil {
+			return fmt.Errorf("failed to re-protect secret subkey for %s: %w", ref, err)
+		}
+		passphrase = hostPassphrase
 	}
 
-	if existing != nil {
+	secHash := sha256Hex(secKey)
+
+	if existing != nil && policy == config.PassphraseInherit {
 		existingPubHash := existing.FieldValue("sha256_public")
 		existingSecHash := existing.FieldValue("sha256_secret")
-		if existingPubHash == pubHash && existingSecHash == secHash {
+		if existingPubHash == pubHash && existingSecHash == secHash &&
+			existing.FieldValue("ownertrust") == trust && existing.FieldValue("passphrase") == passphrase {
 			fmt.Printf("= %s unchanged\n", resolved.ItemTitle)
 			return nil
 		}
 	}
 
 	fields := op.ItemFields{
-		Fingerprint:  resolved.Fingerprint,
-		Algorithm:    meta.Algorithm,
-		Capabilities: meta.Capabilities,
-		UID:          meta.UID,
-		Created:      meta.Created,
-		Expires:      meta.Expires,
-		PublicKey:    string(pubKey),
-		SecretKey:    string(secKey),
-		SHA256Public: pubHash,
-		SHA256Secret: secHash,
+		Fingerprint:      resolved.Fingerprint,
+		Algorithm:        meta.Algorithm,
+		Capabilities:     meta.Capabilities,
+		UID:              meta.UID,
+		Created:          meta.Created,
+		Expires:          meta.Expires,
+		PublicKey:        string(pubKey),
+		SecretKey:        string(secKey),
+		SHA256Public:     pubHash,
+		SHA256Secret:     secHash,
+		Ownertrust:       trust,
+		Passphrase:       passphrase,
+		PassphrasePolicy: storedPolicy,
 	}
 
 	if existing != nil {
-		if err := op.EditItem(resolved.ItemTitle, cfg.Vault, fields); err != nil {
+		if err := op.EditItem(resolved.ItemTitle, vault, fields); err != nil {
 			return fmt.Errorf("failed to update 1Password item %q: %w", resolved.ItemTitle, err)
 		}
 		fmt.Printf("- %s updated\n", resolved.ItemTitle)
 		return nil
 	}
 
-	if err := op.CreateItem(resolved.ItemTitle, cfg.Vault, fields); err != nil {
+	if err := op.CreateItem(resolved.ItemTitle, vault, fields); err != nil {
 		return fmt.Errorf("failed to create 1Password item %q: %w", resolved.ItemTitle, err)
 	}
 
@@ -133,6 +279,40 @@ func syncRef(cfg *config.Config, ref string) error {
 	return nil
 }
 
+// keyPassphrase returns the passphrase stored with a key's backup item, falling
+// back to the one stored in the synced item. An empty result leaves prompting
+// to the gpg-agent pinentry.
+func keyPassphrase(cfg *config.Config, keyName string, existing *op.Item) (string, error) {
+	key, err := cfg.GetKey(keyName)
+	if err != nil {
+		return "", err
+	}
+
+	backup, err := op.GetItem(key.Title, keyVault(cfg, keyName))
+	if err != nil {
+		return "", fmt.Errorf("failed to check 1Password item %q: %w", key.Title, err)
+	}
+	if backup != nil && backup.FieldValue("passphrase") != "" {
+		return backup.FieldValue("passphrase"), nil
+	}
+
+	// A host item with its own policy stores the host's passphrase, not the
+	// key's.
+	if existing != nil && existing.FieldValue("passphrase_policy") == "" {
+		return existing.FieldValue("passphrase"), nil
+	}
+	return "", nil
+}
+
+// generatePassphrase returns a random 32-character passphrase.
+func generatePassphrase() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate passphrase: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
 func sha256Hex(data []byte) string {
 	h := sha256.Sum256(data)
 	return fmt.Sprintf("%x", h)
@@ -0,0 +1,63 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Config scopes accepted by ConfigGet, ConfigSet and ConfigUnset.
+var ConfigScopes = []string{"global", "system", "local", "worktree"}
+
+// ConfigGet returns the value of key in a git config scope. ok is false when
+// the key is not set there.
+func ConfigGet(scope, key string) (value string, ok bool, err error) {
+	cmd := exec.Command("git", "config", "--"+scope, "--get", key)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("git config --get %s failed: %s: %w", key, strings.TrimSpace(stderr.String()), err)
+	}
+
+	return strings.TrimSpace(stdout.String()), true, nil
+}
+
+// ConfigSet sets key to value in a git config scope.
+func ConfigSet(scope, key, value string) error {
+	cmd := exec.Command("git", "config", "--"+scope, key, value)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git config %s failed: %s: %w", key, strings.TrimSpace(stderr.String()), err)
+	}
+	return nil
+}
+
+// ConfigUnset removes key from a git config scope.
+func ConfigUnset(scope, key string) error {
+	cmd := exec.Command("git", "config", "--"+scope, "--unset-all", key)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 5 {
+			return nil
+		}
+		return fmt.Errorf("git config --unset-all %s failed: %s: %w", key, strings.TrimSpace(stderr.String()), err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Show returns the contents of path as of rev in the repository containing it.
+func Show(rev, path string) ([]byte, error) {
+	cmd := exec.Command("git", "-C", filepath.Dir(path), "show", rev+":./"+filepath.Base(path))
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git show failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Revision reads files as of a git revision, for loading a config together
+// with the files it includes.
+type Revision struct {
+	Rev string
+}
+
+// ReadFile returns the contents of path as of the revision.
+func (r Revision) ReadFile(path string) ([]byte, error) {
+	return Show(r.Rev, path)
+}
+
+// Glob returns the files matching pattern as of the revision, in the manner of
+// filepath.Glob. The directory the pattern starts from must exist on disk.
+func (r Revision) Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(pattern)
+	for strings.ContainsAny(dir, `*?[\`) {
+		dir = filepath.Dir(dir)
+	}
+
+	cmd := exec.Command("git", "-C", dir, "ls-tree", "-r", "-z", "--name-only", r.Rev)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git ls-tree failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	var matches []string
+	for _, name := range strings.Split(stdout.String(), "\x00") {
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if ok, _ := filepath.Match(pattern, path); ok {
+			matches = append(matches, path)
+		}
+	}
+	return matches, nil
+}
@@ -3,6 +3,8 @@ package gpg
 import (
 	"bytes"
 	"fmt"
+	"net/url"
+	"os"
 	"os/exec"
 	"strings"
 )
@@ -19,7 +21,52 @@ type KeyMeta struct {
 
 // ExportPublicKey exports the ASCII-armored public key for the given fingerprint.
 func ExportPublicKey(fingerprint string) ([]byte, error) {
-	cmd := exec.Command("gpg", "--batch", "--yes", "--no-tty", "--armor", "--export", fingerprint)
+	return exportPublic([]string{"--armor"}, fingerprint)
+}
+
+// ExportPublicKeys exports the public keys for the given fingerprints as one
+// keyring, ASCII-armored when armor is set.
+func ExportPublicKeys(armor bool, fingerprints ...string) ([]byte, error) {
+	var opts []string
+	if armor {
+		opts = append(opts, "--armor")
+	}
+	return exportPublic(opts, fingerprints...)
+}
+
+// ExportWKDKey exports the binary public keys for the given fingerprints,
+// minimized and stripped to the user IDs with the given mail address, as
+// served from a Web Key Directory.
+func ExportWKDKey(mbox string, fingerprints ...string) ([]byte, error) {
+	return exportPublic([]string{"--export-options", "export-minimal", "--export-filter", "keep-uid=mbox=" + mbox}, fingerprints...)
+}
+
+// NormalizePublicKey imports a public key, for example one fetched from a
+// keyserver, into an isolated temporary keyring and returns it re-exported
+// the way ExportPublicKey would, so the result can be compared byte for byte.
+func NormalizePublicKey(armored []byte, fingerprint string) ([]byte, error) {
+	home, err := os.MkdirTemp("", "keysync-gpg-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary keyring: %w", err)
+	}
+	defer os.RemoveAll(home)
+	defer exec.Command("gpgconf", "--homedir", home, "--kill", "all").Run()
+
+	cmd := exec.Command("gpg", "--homedir", home, "--batch", "--yes", "--no-tty", "--import")
+	cmd.Stdin = bytes.NewReader(armored)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg --import into temporary keyring failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return exportPublic([]string{"--homedir", home, "--armor"}, fingerprint)
+}
+
+func exportPublic(opts []string, fingerprints ...string) ([]byte, error) {
+	args := append([]string{"--batch", "--yes", "--no-tty"}, opts...)
+	args = append(args, "--export")
+	cmd := exec.Command("gpg", append(args, fingerprints...)...)
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -31,15 +78,20 @@ func ExportPublicKey(fingerprint string) ([]byte, error) {
 	}
 
 	if stdout.Len() == 0 {
-		return nil, fmt.Errorf("gpg --export returned empty output for %s", fingerprint)
+		return nil, fmt.Errorf("gpg --export returned empty output for %s", strings.Join(fingerprints, " "))
 	}
 
 	return stdout.Bytes(), nil
 }
 
 // ExportSecretKey exports the ASCII-armored secret key for the given fingerprint.
-func ExportSecretKey(fingerprint string) ([]byte, error) {
-	cmd := exec.Command("gpg", "--batch", "--yes", "--no-tty", "--armor", "--export-secret-keys", fingerprint)
+// A non-empty passphrase is supplied through loopback pinentry.
+func ExportSecretKey(fingerprint, passphrase string) ([]byte, error) {
+	cmd, cleanup, err := gpgCommand(passphrase, "--armor", "--export-secret-keys", fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -58,13 +110,18 @@ func ExportSecretKey(fingerprint string) ([]byte, error) {
 }
 
 // ExportSecretSubkey exports the ASCII-armored secret subkey material for a key fingerprint.
-func ExportSecretSubkey(fingerprint string) ([]byte, error) {
+// A non-empty passphrase is supplied through loopback pinentry.
+func ExportSecretSubkey(fingerprint, passphrase string) ([]byte, error) {
 	locked := fingerprint
 	if !strings.HasSuffix(locked, "!") {
 		locked += "!"
 	}
 
-	cmd := exec.Command("gpg", "--batch", "--yes", "--no-tty", "--armor", "--export-secret-subkeys", locked)
+	cmd, cleanup, err := gpgCommand(passphrase, "--armor", "--export-secret-subkeys", locked)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -82,6 +139,27 @@ func ExportSecretSubkey(fingerprint string) ([]byte, error) {
 	return stdout.Bytes(), nil
 }
 
+// ExportSSHKey exports a subkey in OpenSSH public key format.
+func ExportSSHKey(fingerprint string) (string, error) {
+	locked := fingerprint
+	if !strings.HasSuffix(locked, "!") {
+		locked += "!"
+	}
+
+	cmd := exec.Command("gpg", "--batch", "--yes", "--no-tty", "--export-ssh-key", locked)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("gpg --export-ssh-key failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
 // ReadKeyMeta reads key metadata for a fingerprint using gpg --with-colons output.
 func ReadKeyMeta(fingerprint string) (*KeyMeta, error) {
 	cmd := exec.Command("gpg", "--batch", "--yes", "--no-tty", "--with-colons", "--fixed-list-mode", "--list-keys", fingerprint)
@@ -138,6 +216,27 @@ func ReadKeyMeta(fingerprint string) (*KeyMeta, error) {
 	return meta, nil
 }
 
+// HomeDir returns the GnuPG home directory in use.
+func HomeDir() (string, error) {
+	cmd := exec.Command("gpgconf", "--list-dirs", "homedir")
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("gpgconf --list-dirs homedir failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	// gpgconf percent-escapes special characters such as ':' in paths.
+	home := strings.TrimSpace(stdout.String())
+	if unescaped, err := url.PathUnescape(home); err == nil {
+		home = unescaped
+	}
+	return home, nil
+}
+
 func algoName(id string) string {
 	switch id {
 	case "1":
@@ -0,0 +1,45 @@
+package gpg
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// GenerateKey creates a new certify-only primary key and returns its fingerprint.
+// The passphrase is requested through the gpg-agent pinentry.
+func GenerateKey(uid, algorithm, expires string) (string, error) {
+	return generate("--quick-generate-key", uid, algorithm, "cert", expires)
+}
+
+// AddSubkey adds a subkey with the given usage (for example "encr" or "sign,auth")
+// to a primary key and returns the new subkey fingerprint.
+func AddSubkey(primaryFP, algorithm, usage, expires string) (string, error) {
+	return generate("--quick-add-key", primaryFP, algorithm, usage, expires)
+}
+
+func generate(op string, args ...string) (string, error) {
+	cmdArgs := []string{"--batch", "--no-tty", "--pinentry-mode", "ask", "--status-fd", "1", op}
+	cmdArgs = append(cmdArgs, args...)
+	cmd := exec.Command("gpg", cmdArgs...)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("gpg %s failed: %s: %w", op, strings.TrimSpace(stderr.String()), err)
+	}
+
+	// [GNUPG:] KEY_CREATED <P|S|B> <fingerprint>
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && fields[0] == "[GNUPG:]" && fields[1] == "KEY_CREATED" {
+			return fields[3], nil
+		}
+	}
+
+	return "", fmt.Errorf("gpg %s did not report a created key", op)
+}
@@ -8,12 +8,14 @@ import (
 )
 
 // ImportKey imports an ASCII-armored key (public or secret) into the GPG keyring.
-// The key material is passed via stdin to avoid writing to disk.
-func ImportKey(armoredKey []byte) error {
-	cmd := exec.Command("gpg",
-		"--batch", "--yes", "--no-tty",
-		"--import",
-	)
+// The key material is passed via stdin to avoid writing to disk. A non-empty
+// passphrase is supplied through loopback pinentry.
+func ImportKey(armoredKey []byte, passphrase string) error {
+	cmd, cleanup, err := gpgCommand(passphrase, "--import")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
 	cmd.Stdin = bytes.NewReader(armoredKey)
 
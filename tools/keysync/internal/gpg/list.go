@@ -0,0 +1,132 @@
+package gpg
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// KeyInfo describes a primary key or subkey parsed from gpg --with-colons output.
+type KeyInfo struct {
+	Fingerprint  string
+	Keygrip      string
+	Algorithm    string
+	Capabilities string
+	Validity     string
+	Created      string
+	Expires      string
+	HasSecret    bool
+	UIDs         []string
+	Subkeys      []*KeyInfo
+	// CardSerial is the serial number of the smartcard holding the secret
+	// key, which cannot be exported.
+	CardSerial string
+}
+
+// Find returns the primary key or subkey with the given fingerprint.
+func (k *KeyInfo) Find(fingerprint string) *KeyInfo {
+	if strings.EqualFold(k.Fingerprint, fingerprint) {
+		return k
+	}
+	for _, sub := range k.Subkeys {
+		if strings.EqualFold(sub.Fingerprint, fingerprint) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// ListKeys lists keyring keys matching the given fingerprints, including
+// keygrips. All keys are listed when no fingerprint is given. With secret set,
+// only keys with secret material are listed.
+func ListKeys(secret bool, fingerprints ...string) ([]*KeyInfo, error) {
+	listOp := "--list-keys"
+	if secret {
+		listOp = "--list-secret-keys"
+	}
+	args := []string{"--batch", "--yes", "--no-tty", "--with-colons", "--fixed-list-mode", "--with-keygrip", listOp}
+	args = append(args, fingerprints...)
+	cmd := exec.Command("gpg", args...)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg %s failed: %s: %w", listOp, strings.TrimSpace(stderr.String()), err)
+	}
+
+	return parseColons(stdout.String()), nil
+}
+
+// ShowKeys parses an armored public key without importing it.
+func ShowKeys(armored []byte) ([]*KeyInfo, error) {
+	cmd := exec.Command("gpg", "--batch", "--no-tty", "--with-colons", "--fixed-list-mode", "--show-keys")
+	cmd.Stdin = bytes.NewReader(armored)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg --show-keys failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return parseColons(stdout.String()), nil
+}
+
+func parseColons(out string) []*KeyInfo {
+	var keys []*KeyInfo
+	var current *KeyInfo
+	var last *KeyInfo
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 10 {
+			continue
+		}
+
+		switch fields[0] {
+		case "pub", "sec", "sub", "ssb":
+			info := &KeyInfo{
+				Validity:  fields[1],
+				Algorithm: algoName(fields[3]),
+				Created:   fields[5],
+				Expires:   fields[6],
+			}
+			if len(fields) > 11 {
+				info.Capabilities = fields[11]
+			}
+			if fields[0] == "sec" || fields[0] == "ssb" {
+				info.HasSecret = len(fields) <= 14 || fields[14] != "#"
+				if info.HasSecret && len(fields) > 14 && fields[14] != "" && fields[14] != "+" {
+					info.CardSerial = fields[14]
+				}
+			}
+			if fields[0] == "pub" || fields[0] == "sec" {
+				current = info
+				keys = append(keys, info)
+			} else if current != nil {
+				current.Subkeys = append(current.Subkeys, info)
+			}
+			last = info
+		case "fpr":
+			if last != nil && last.Fingerprint == "" {
+				last.Fingerprint = fields[9]
+			}
+		case "grp":
+			if last != nil && last.Keygrip == "" {
+				last.Keygrip = fields[9]
+			}
+		case "uid":
+			if current != nil {
+				current.UIDs = append(current.UIDs, fields[9])
+			}
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,151 @@
+package gpg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// passphraseArgs returns the gpg arguments and extra file that feed a
+// passphrase through --passphrase-fd in loopback pinentry mode. Both are nil
+// when passphrase is empty, leaving prompts to the gpg-agent pinentry.
+// The returned file must be attached as the command's first extra file (fd 3)
+// and closed once the command has run.
+func passphraseArgs(passphrase string) ([]string, *os.File, error) {
+	if passphrase == "" {
+		return nil, nil, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create passphrase pipe: %w", err)
+	}
+	if _, err := io.WriteString(w, passphrase+"\n"); err != nil {
+		r.Close()
+		w.Close()
+		return nil, nil, fmt.Errorf("cannot write passphrase pipe: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		r.Close()
+		return nil, nil, fmt.Errorf("cannot write passphrase pipe: %w", err)
+	}
+
+	return []string{"--pinentry-mode", "loopback", "--passphrase-fd", "3"}, r, nil
+}
+
+// gpgCommand builds a gpg command whose passphrase, when set, is supplied
+// through passphraseArgs. The returned cleanup func must be called after the
+// command has run.
+func gpgCommand(passphrase string, args ...string) (*exec.Cmd, func(), error) {
+	extraArgs, passFile, err := passphraseArgs(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmdArgs := append([]string{"--batch", "--yes", "--no-tty"}, extraArgs...)
+	cmdArgs = append(cmdArgs, args...)
+	cmd := exec.Command("gpg", cmdArgs...)
+
+	cleanup := func() {}
+	if passFile != nil {
+		cmd.ExtraFiles = []*os.File{passFile}
+		cleanup = func() { passFile.Close() }
+	}
+	return cmd, cleanup, nil
+}
+
+// PresetPassphrase caches a passphrase in gpg-agent for a keygrip using
+// gpg-preset-passphrase. The agent must run with allow-preset-passphrase.
+func PresetPassphrase(keygrip, passphrase string) error {
+	libexec := exec.Command("gpgconf", "--list-dirs", "libexecdir")
+	var dir bytes.Buffer
+	var stderr bytes.Buffer
+	libexec.Stdout = &dir
+	libexec.Stderr = &stderr
+	if err := libexec.Run(); err != nil {
+		return fmt.Errorf("gpgconf --list-dirs libexecdir failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	preset := filepath.Join(strings.TrimSpace(dir.String()), "gpg-preset-passphrase")
+	cmd := exec.Command(preset, "--preset", keygrip)
+	cmd.Stdin = strings.NewReader(passphrase)
+
+	stderr.Reset()
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gpg-preset-passphrase failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return nil
+}
+
+// ReprotectSecretSubkey re-encrypts exported secret subkey material to a new
+// passphrase inside an isolated temporary keyring and returns the re-exported
+// ASCII-armored subkey. An empty newPassphrase leaves the subkey unprotected.
+func ReprotectSecretSubkey(publicKey, secretSubkey []byte, subkeyFP, oldPassphrase, newPassphrase string) ([]byte, error) {
+	home, err := os.MkdirTemp("", "keysync-gpg-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary keyring: %w", err)
+	}
+	defer os.RemoveAll(home)
+	defer exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+
+	locked := subkeyFP
+	if !strings.HasSuffix(locked, "!") {
+		locked += "!"
+	}
+
+	importCmd, cleanup, err := gpgCommand(oldPassphrase, "--homedir", home, "--import")
+	if err != nil {
+		return nil, err
+	}
+	importCmd.Stdin = io.MultiReader(bytes.NewReader(publicKey), bytes.NewReader(secretSubkey))
+	var stderr bytes.Buffer
+	importCmd.Stderr = &stderr
+	err = importCmd.Run()
+	cleanup()
+	if err != nil {
+		return nil, fmt.Errorf("gpg --import into temporary keyring failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	// In loopback mode --passwd asks for the passphrases on the command fd:
+	// the current one (only when the key is protected), then the new one.
+	var answers strings.Builder
+	if oldPassphrase != "" {
+		answers.WriteString(oldPassphrase + "\n")
+	}
+	answers.WriteString(newPassphrase + "\n")
+
+	passwd := exec.Command("gpg", "--homedir", home, "--batch", "--no-tty",
+		"--pinentry-mode", "loopback", "--command-fd", "0", "--passwd", locked)
+	passwd.Stdin = strings.NewReader(answers.String())
+	stderr.Reset()
+	passwd.Stderr = &stderr
+	if err := passwd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg --passwd failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	exportCmd, cleanup, err := gpgCommand(newPassphrase, "--homedir", home, "--armor", "--export-secret-subkeys", locked)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var stdout bytes.Buffer
+	exportCmd.Stdout = &stdout
+	stderr.Reset()
+	exportCmd.Stderr = &stderr
+	if err := exportCmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg --export-secret-subkeys from temporary keyring failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("gpg --export-secret-subkeys returned empty output for %s", locked)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,35 @@
+package gpg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// TestSign makes a detached signature over a fixed message the way git does,
+// running program with --status-fd=2 -bsau keyID, and reports an error unless
+// gpg confirms the signature was created.
+func TestSign(program, keyID string) error {
+	cmd := exec.Command(program, "--status-fd=2", "-bsau", keyID)
+	cmd.Stdin = strings.NewReader("keysync test signature\n")
+
+	var stderr bytes.Buffer
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s -bsau %s failed: %s: %w", program, keyID, strings.TrimSpace(stderr.String()), err)
+	}
+
+	// [GNUPG:] SIG_CREATED <type> <pk_algo> <hash_algo> <class> <timestamp> <fingerprint>
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "[GNUPG:]" && fields[1] == "SIG_CREATED" {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s -bsau %s did not report a created signature", program, keyID)
+}
@@ -0,0 +1,54 @@
+package gpg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// EnableSSHKey adds a keygrip to gpg-agent's sshcontrol file so the key is
+// offered over the SSH agent protocol. ttl is the passphrase cache TTL in
+// seconds (0 for the agent default) and confirm makes the agent ask before
+// each use. An existing entry is left alone, including one disabled with "!"
+// and its TTL and flags. It reports whether the file changed.
+func EnableSSHKey(keygrip string, ttl int, confirm bool) (bool, error) {
+	home, err := HomeDir()
+	if err != nil {
+		return false, err
+	}
+	path := filepath.Join(home, "sshcontrol")
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("cannot read sshcontrol: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSpace(strings.TrimPrefix(trimmed, "!")))
+		if len(fields) > 0 && strings.EqualFold(fields[0], keygrip) {
+			return false, nil
+		}
+	}
+
+	entry := strings.ToUpper(keygrip) + " " + strconv.Itoa(ttl)
+	if confirm {
+		entry += " confirm"
+	}
+	updated := string(data)
+	if updated != "" && !strings.HasSuffix(updated, "\n") {
+		updated += "\n"
+	}
+	updated += entry + "\n"
+
+	if err := os.WriteFile(path, []byte(updated), 0o600); err != nil {
+		return false, fmt.Errorf("cannot write sshcontrol: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,78 @@
+package gpg
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/OnTheWehn333/keysync/internal/config"
+)
+
+// firstTrustLevel is the gpg --export-ownertrust level of
+// config.TrustLevels[0]; the rest follow in order.
+const firstTrustLevel = 2
+
+// trustLevelMask selects the level from an --export-ownertrust value; the
+// bits above it are flags, such as 128 for a disabled key.
+const trustLevelMask = 0x0f
+
+// ExportOwnertrust returns the owner-trust name of a primary key, or "" when
+// gpg has no owner-trust recorded for it. Whether the key is disabled is not
+// part of its owner-trust and is ignored.
+func ExportOwnertrust(fingerprint string) (string, error) {
+	cmd := exec.Command("gpg", "--batch", "--yes", "--no-tty", "--export-ownertrust")
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("gpg --export-ownertrust failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	// Lines look like "<fingerprint>:<level>:"; comments start with '#'.
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || !strings.EqualFold(fields[0], fingerprint) {
+			continue
+		}
+		level, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid owner-trust line %q", line)
+		}
+		level &= trustLevelMask
+		if i := level - firstTrustLevel; i >= 0 && i < len(config.TrustLevels) {
+			return config.TrustLevels[i], nil
+		}
+		return "", fmt.Errorf("unknown owner-trust level %d for %s", level, fingerprint)
+	}
+
+	return "", nil
+}
+
+// ImportOwnertrust sets the owner-trust of a primary key by name.
+func ImportOwnertrust(fingerprint, trust string) error {
+	i := slices.Index(config.TrustLevels, trust)
+	if i < 0 {
+		return fmt.Errorf("unknown owner-trust %q (expected one of %s)", trust, strings.Join(config.TrustLevels, ", "))
+	}
+
+	cmd := exec.Command("gpg", "--batch", "--yes", "--no-tty", "--import-ownertrust")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s:%d:\n", strings.ToUpper(fingerprint), firstTrustLevel+i))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gpg --import-ownertrust failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return nil
+}
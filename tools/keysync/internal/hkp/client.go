@@ -0,0 +1,94 @@
+package hkp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultKeyserver is used when neither the config nor a flag names one.
+const DefaultKeyserver = "hkps://keys.openpgp.org"
+
+// Client talks to an HKP keyserver.
+type Client struct {
+	// BaseURL is the keyserver root, with hkp:// and hkps:// already mapped
+	// to http:// and https://.
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for an hkp://, hkps://, http:// or https://
+// keyserver URL. hkp:// without a port uses the HKP port 11371.
+func NewClient(keyserver string) (*Client, error) {
+	u, err := url.Parse(strings.TrimSpace(keyserver))
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyserver %q: %w", keyserver, err)
+	}
+
+	switch u.Scheme {
+	case "hkp":
+		u.Scheme = "http"
+		if u.Port() == "" {
+			u.Host += ":11371"
+		}
+	case "hkps":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid keyserver %q: scheme must be hkp, hkps, http or https", keyserver)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid keyserver %q: missing host", keyserver)
+	}
+
+	return &Client{
+		BaseURL:    strings.TrimRight(u.String(), "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Add uploads an ASCII-armored public key with POST /pks/add.
+func (c *Client) Add(armored []byte) error {
+	form := url.Values{"keytext": {string(armored)}}
+	resp, err := c.HTTPClient.PostForm(c.BaseURL+"/pks/add", form)
+	if err != nil {
+		return fmt.Errorf("hkp add failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("hkp add failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Lookup fetches the ASCII-armored public key for a fingerprint with
+// GET /pks/lookup?op=get. It returns nil when the keyserver has no such key.
+func (c *Client) Lookup(fingerprint string) ([]byte, error) {
+	query := url.Values{
+		"op":      {"get"},
+		"options": {"mr"},
+		"search":  {"0x" + strings.ToUpper(fingerprint)},
+	}
+	resp, err := c.HTTPClient.Get(c.BaseURL + "/pks/lookup?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("hkp lookup failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("hkp lookup failed: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("hkp lookup failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
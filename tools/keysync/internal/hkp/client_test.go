@@ -0,0 +1,113 @@
+package hkp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const armored = "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmDMEZ...\n-----END PGP PUBLIC KEY BLOCK-----\n"
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		keyserver string
+		want      string
+		wantErr   bool
+	}{
+		{keyserver: "hkps://keys.openpgp.org", want: "https://keys.openpgp.org"},
+		{keyserver: "hkp://keyserver.ubuntu.com", want: "http://keyserver.ubuntu.com:11371"},
+		{keyserver: "hkp://keys.example.org:80/", want: "http://keys.example.org:80"},
+		{keyserver: "https://keys.example.org/", want: "https://keys.example.org"},
+		{keyserver: "ldap://keys.example.org", wantErr: true},
+		{keyserver: "hkps://", wantErr: true},
+	}
+	for _, tt := range tests {
+		client, err := NewClient(tt.keyserver)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewClient(%q) succeeded, want error", tt.keyserver)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewClient(%q): %v", tt.keyserver, err)
+			continue
+		}
+		if client.BaseURL != tt.want {
+			t.Errorf("NewClient(%q).BaseURL = %q, want %q", tt.keyserver, client.BaseURL, tt.want)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/pks/add" {
+			http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+			return
+		}
+		got = r.PostFormValue("keytext")
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL, HTTPClient: server.Client()}
+	if err := client.Add([]byte(armored)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if got != armored {
+		t.Errorf("keytext = %q, want %q", got, armored)
+	}
+}
+
+func TestAddRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "key too large", http.StatusRequestEntityTooLarge)
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL, HTTPClient: server.Client()}
+	err := client.Add([]byte(armored))
+	if err == nil || !strings.Contains(err.Error(), "key too large") {
+		t.Errorf("Add error = %v, want the server's message", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	const fingerprint = "30ae3a7aec728ca5f67eb0361f7098d2ae57c8a3"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/pks/lookup" || query.Get("op") != "get" || query.Get("options") != "mr" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		switch query.Get("search") {
+		case "0x" + strings.ToUpper(fingerprint):
+			w.Write([]byte(armored))
+		case "0xFAIL":
+			http.Error(w, "database unavailable", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL, HTTPClient: server.Client()}
+
+	got, err := client.Lookup(fingerprint)
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if string(got) != armored {
+		t.Errorf("Lookup = %q, want %q", got, armored)
+	}
+
+	got, err = client.Lookup("0000000000000000000000000000000000000000")
+	if err != nil || got != nil {
+		t.Errorf("Lookup of a missing key = %q, %v, want nil, nil", got, err)
+	}
+
+	if _, err := client.Lookup("fail"); err == nil || !strings.Contains(err.Error(), "database unavailable") {
+		t.Errorf("Lookup error = %v, want the server's message", err)
+	}
+}
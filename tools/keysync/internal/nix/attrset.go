@@ -0,0 +1,56 @@
+package nix
+
+import (
+	"sort"
+	"strings"
+)
+
+// RenderAttrset renders a flat Nix attrset of string values with attribute
+// names in sorted order. Comment lines are written at the top of the set.
+func RenderAttrset(comments []string, values map[string]string) []byte {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, comment := range comments {
+		b.WriteString("  # " + comment + "\n")
+	}
+	for _, name := range names {
+		b.WriteString("  " + Attr(name) + " = " + String(values[name]) + ";\n")
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// String renders a Nix double-quoted string literal.
+func String(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// Attr renders an attribute name, quoting it when it is not a plain identifier.
+func Attr(name string) string {
+	if isIdentifier(name) {
+		return name
+	}
+	return String(name)
+}
+
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		case i > 0 && (c == '-' || c == '\'' || (c >= '0' && c <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package nix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ReadJSON returns the JSON at source: the evaluated expression when source
+// is a .nix file, the contents of any other file, such as a config written by
+// the home-manager module, and otherwise the result of nix eval --json on
+// source as an installable, such as .#keysync.
+func ReadJSON(source string) ([]byte, error) {
+	if strings.HasSuffix(source, ".nix") {
+		if _, err := os.Stat(source); err == nil {
+			return eval(source, "--file", source)
+		}
+	}
+	data, err := os.ReadFile(source)
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read %s: %w", source, err)
+	}
+	return Eval(source)
+}
+
+// Eval evaluates an installable with nix eval --json.
+func Eval(installable string) ([]byte, error) {
+	return eval(installable, installable)
+}
+
+func eval(source string, args ...string) ([]byte, error) {
+	cmd := exec.Command("nix", append([]string{"eval", "--json"}, args...)...)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("nix eval %s failed: %s: %w", source, strings.TrimSpace(stderr.String()), err)
+	}
+
+	return stdout.Bytes(), nil
+}
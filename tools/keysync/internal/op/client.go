@@ -9,11 +9,39 @@ import (
 	"time"
 )
 
+// Vault names a 1Password vault and the account it belongs to. An empty
+// Account uses the default signed-in account.
+type Vault struct {
+	Name    string
+	Account string
+}
+
+// String returns the vault name, followed by the account when set.
+func (v Vault) String() string {
+	if v.Account == "" {
+		return v.Name
+	}
+	return v.Name + " (account " + v.Account + ")"
+}
+
+// args returns the op flags that select the vault.
+func (v Vault) args() []string {
+	args := []string{"--vault", v.Name}
+	if v.Account != "" {
+		args = append(args, "--account", v.Account)
+	}
+	return args
+}
+
 // Item represents a 1Password item.
 type Item struct {
-	ID     string  `json:"id"`
-	Title  string  `json:"title"`
-	Fields []Field `json:"fields"`
+	ID       string   `json:"id,omitempty"`
+	Title    string   `json:"title"`
+	Category string   `json:"category,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Fields   []Field  `json:"fields"`
+	// Sections are passed through unchanged when editing an item.
+	Sections json.RawMessage `json:"sections,omitempty"`
 }
 
 // Field represents a 1Password item field.
@@ -23,9 +51,12 @@ type Field struct {
 	Value   string `json:"value"`
 	Type    string `json:"type"`
 	Purpose string `json:"purpose,omitempty"`
+	// Section is passed through unchanged when editing an item.
+	Section json.RawMessage `json:"section,omitempty"`
 }
 
-// ItemFields contains the key payload and metadata stored in 1Password.
+// ItemFields contains the key payload and metadata stored in 1Password. Empty
+// values are not stored.
 type ItemFields struct {
 	Fingerprint  string
 	Algorithm    string
@@ -37,13 +68,20 @@ type ItemFields struct {
 	SecretKey    string
 	SHA256Public string
 	SHA256Secret string
-	SyncedAt     string
+	Ownertrust   string
+	Passphrase   string
+	// PassphrasePolicy is only stored for per-host items.
+	PassphrasePolicy string
+	// RevocationCert is only stored for top-level key backups.
+	RevocationCert string
+	SyncedAt       string
 }
 
-// FieldValue returns the value of the first field matching the given label.
+// FieldValue returns the value of the first field outside any section
+// matching the given label.
 func (item *Item) FieldValue(label string) string {
 	for _, f := range item.Fields {
-		if f.Label == label {
+		if f.Label == label && f.topLevel() {
 			return f.Value
 		}
 	}
@@ -57,27 +95,37 @@ func opCmd(args ...string) *exec.Cmd {
 	return cmd
 }
 
-// EnsureSignedIn verifies the 1Password CLI has an active signed-in account.
-func EnsureSignedIn() error {
-	cmd := opCmd("whoami", "--format", "json")
+// EnsureSignedIn verifies the 1Password CLI has an active session for each
+// account, or for the default account when none is given.
+func EnsureSignedIn(accounts ...string) error {
+	if len(accounts) == 0 {
+		accounts = []string{""}
+	}
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	for _, account := range accounts {
+		args := []string{"whoami", "--format", "json"}
+		signin := "eval $(op signin)"
+		if account != "" {
+			args = append(args, "--account", account)
+			signin = "eval $(op signin --account " + account + ")"
+		}
+		cmd := opCmd(args...)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("1Password CLI not signed in. Sign in first:\n  eval $(op signin)\nor enable desktop app integration:\n  https://developer.1password.com/docs/cli/app-integration/")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("1Password CLI not signed in. Sign in first:\n  %s\nor enable desktop app integration:\n  https://developer.1password.com/docs/cli/app-integration/", signin)
+		}
 	}
 
 	return nil
 }
 
 // GetItem fetches an item by title and vault.
-func GetItem(title, vault string) (*Item, error) {
-	cmd := opCmd("item", "get", title,
-		"--vault", vault,
-		"--format", "json",
-		"--reveal",
-	)
+func GetItem(title string, vault Vault) (*Item, error) {
+	args := append([]string{"item", "get", title}, vault.args()...)
+	cmd := opCmd(append(args, "--format", "json", "--reveal")...)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -100,62 +148,159 @@ func GetItem(title, vault string) (*Item, error) {
 	return &item, nil
 }
 
+// ListItems lists the items in a vault carrying the given tag. Listed items
+// have no fields; fetch them with GetItem.
+func ListItems(vault Vault, tag string) ([]Item, error) {
+	args := append([]string{"item", "list"}, vault.args()...)
+	cmd := opCmd(append(args, "--tags", tag, "--format", "json")...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("op item list failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	var items []Item
+	if err := json.Unmarshal(stdout.Bytes(), &items); err != nil {
+		return nil, fmt.Errorf("failed to parse op output: %w", err)
+	}
+
+	return items, nil
+}
+
 // CreateItem creates a new 1Password item with the provided fields.
-func CreateItem(title, vault string, fields ItemFields) error {
-	args := []string{
-		"item", "create",
-		"--category", "Secure Note",
-		"--vault", vault,
-		"--title", title,
-		"--tags", "keysync",
+func CreateItem(title string, vault Vault, fields ItemFields) error {
+	item := Item{
+		Title:    title,
+		Category: "SECURE_NOTE",
+		Tags:     []string{"keysync"},
+		Fields:   fields.fields(),
 	}
-	args = append(args, fieldAssignments(fields)...)
+	return writeItem("create", append([]string{"item", "create"}, vault.args()...), item)
+}
+
+// EditItem updates an existing 1Password item with the provided fields. Fields
+// keysync manages are replaced as a whole, so an empty value removes the field;
+// other fields of the item, including ones in a section that share a managed
+// field's label, are kept.
+func EditItem(title string, vault Vault, fields ItemFields) error {
+	item, err := GetItem(title, vault)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("op item edit failed: %q isn't an item in vault %s", title, vault)
+	}
+
+	kept := item.Fields[:0]
+	for _, f := range item.Fields {
+		if !f.managed() {
+			kept = append(kept, f)
+		}
+	}
+	item.Fields = append(kept, fields.fields()...)
+
+	return writeItem("edit", append([]string{"item", "edit", item.ID}, vault.args()...), *item)
+}
 
-	cmd := opCmd(args...)
+// writeItem runs op item create or edit with the item as a JSON template on
+// stdin, so that secret field values never appear in the process list.
+func writeItem(verb string, args []string, item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode item %q: %w", item.Title, err)
+	}
+
+	cmd := opCmd(append(args, "--template", "/dev/stdin")...)
+	cmd.Stdin = bytes.NewReader(data)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("op item create failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+		return fmt.Errorf("op item %s failed: %s: %w", verb, strings.TrimSpace(stderr.String()), err)
 	}
 	return nil
 }
 
-// EditItem updates an existing 1Password item with the provided fields.
-func EditItem(title, vault string, fields ItemFields) error {
-	args := []string{
-		"item", "edit", title,
-		"--vault", vault,
+// ArchiveItem renames an item and replaces its tags so it no longer matches
+// active keysync items.
+func ArchiveItem(title string, vault Vault, newTitle string, tags []string) error {
+	args := append([]string{"item", "edit", title}, vault.args()...)
+	cmd := opCmd(append(args,
+		"--title", newTitle,
+		"--tags", strings.Join(tags, ","),
+	)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("op item edit failed: %s: %w", strings.TrimSpace(stderr.String()), err)
 	}
-	args = append(args, fieldAssignments(fields)...)
+	return nil
+}
 
-	cmd := opCmd(args...)
+// DeleteItem permanently deletes an item by title.
+func DeleteItem(title string, vault Vault) error {
+	cmd := opCmd(append([]string{"item", "delete", title}, vault.args()...)...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("op item edit failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+		return fmt.Errorf("op item delete failed: %s: %w", strings.TrimSpace(stderr.String()), err)
 	}
 	return nil
 }
 
-func fieldAssignments(f ItemFields) []string {
+// managedFields are the labels of the fields ItemFields writes, which it
+// writes outside any section.
+var managedFields = map[string]bool{
+	"fingerprint": true, "algorithm": true, "capabilities": true, "uid": true,
+	"created": true, "expires": true, "public_key": true, "secret_key": true,
+	"sha256_public": true, "sha256_secret": true, "synced_at": true,
+	"ownertrust": true, "passphrase": true, "passphrase_policy": true,
+	"revocation_cert": true,
+}
+
+// topLevel reports whether the field is outside any section.
+func (f Field) topLevel() bool {
+	return len(f.Section) == 0 || string(f.Section) == "null"
+}
+
+// managed reports whether the field is one ItemFields writes, rather than one
+// with the same label the user added to a section.
+func (f Field) managed() bool {
+	return managedFields[f.Label] && f.topLevel()
+}
+
+// fields returns the item fields to store, leaving out empty values.
+func (f ItemFields) fields() []Field {
 	now := f.SyncedAt
 	if now == "" {
 		now = time.Now().UTC().Format(time.RFC3339)
 	}
 
-	return []string{
-		fmt.Sprintf("fingerprint[text]=%s", f.Fingerprint),
-		fmt.Sprintf("algorithm[text]=%s", f.Algorithm),
-		fmt.Sprintf("capabilities[text]=%s", f.Capabilities),
-		fmt.Sprintf("uid[text]=%s", f.UID),
-		fmt.Sprintf("created[text]=%s", f.Created),
-		fmt.Sprintf("expires[text]=%s", f.Expires),
-		fmt.Sprintf("public_key[text]=%s", f.PublicKey),
-		fmt.Sprintf("secret_key[concealed]=%s", f.SecretKey),
-		fmt.Sprintf("sha256_public[text]=%s", f.SHA256Public),
-		fmt.Sprintf("sha256_secret[text]=%s", f.SHA256Secret),
-		fmt.Sprintf("synced_at[text]=%s", now),
+	var fields []Field
+	add := func(label, fieldType, value string) {
+		if value != "" {
+			fields = append(fields, Field{ID: label, Label: label, Type: fieldType, Value: value})
+		}
 	}
+	add("fingerprint", "STRING", f.Fingerprint)
+	add("algorithm", "STRING", f.Algorithm)
+	add("capabilities", "STRING", f.Capabilities)
+	add("uid", "STRING", f.UID)
+	add("created", "STRING", f.Created)
+	add("expires", "STRING", f.Expires)
+	add("public_key", "STRING", f.PublicKey)
+	add("secret_key", "CONCEALED", f.SecretKey)
+	add("sha256_public", "STRING", f.SHA256Public)
+	add("sha256_secret", "STRING", f.SHA256Secret)
+	add("synced_at", "STRING", now)
+	add("ownertrust", "STRING", f.Ownertrust)
+	add("passphrase", "CONCEALED", f.Passphrase)
+	add("passphrase_policy", "STRING", f.PassphrasePolicy)
+	add("revocation_cert", "CONCEALED", f.RevocationCert)
+	return fields
 }
@@ -0,0 +1,39 @@
+package sops
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// UpdateKeys re-encrypts a file's data key to the recipients of its matching
+// creation rule in configPath.
+func UpdateKeys(configPath, path string) error {
+	cmd := exec.Command("sops", "--config", configPath, "updatekeys", "--yes", path)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sops updatekeys failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return nil
+}
+
+// Decrypt checks that path decrypts with the keys available to the local
+// gpg-agent. The plaintext is discarded.
+func Decrypt(path string) error {
+	cmd := exec.Command("sops", "--decrypt", path)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sops --decrypt failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package sops
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type metadata struct {
+	Sops *struct {
+		MAC       string   `yaml:"mac"`
+		PGP       []pgpKey `yaml:"pgp"`
+		KeyGroups []struct {
+			PGP []pgpKey `yaml:"pgp"`
+		} `yaml:"key_groups"`
+	} `yaml:"sops"`
+}
+
+type pgpKey struct {
+	FP string `yaml:"fp"`
+}
+
+// Recipients returns the sorted, upper-cased PGP fingerprints a sops-encrypted
+// YAML or JSON file is encrypted to. ok is false when the file holds no sops
+// metadata.
+func Recipients(path string) (fps []string, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+
+	var meta metadata
+	if err := yaml.Unmarshal(data, &meta); err != nil || meta.Sops == nil || meta.Sops.MAC == "" {
+		return nil, false, nil
+	}
+
+	keys := meta.Sops.PGP
+	for _, group := range meta.Sops.KeyGroups {
+		keys = append(keys, group.PGP...)
+	}
+
+	seen := make(map[string]struct{})
+	for _, key := range keys {
+		fp := strings.ToUpper(strings.TrimSpace(key.FP))
+		if fp == "" {
+			continue
+		}
+		if _, dup := seen[fp]; dup {
+			continue
+		}
+		seen[fp] = struct{}{}
+		fps = append(fps, fp)
+	}
+	sort.Strings(fps)
+	return fps, true, nil
+}
@@ -0,0 +1,227 @@
+package sops
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is one .sops.yaml creation rule encrypting to PGP fingerprints.
+type Rule struct {
+	PathRegex string
+	PGP       []string
+}
+
+// Equal reports whether two rules match the same paths and fingerprints.
+func (r Rule) Equal(other Rule) bool {
+	return r.PathRegex == other.PathRegex && slices.EqualFunc(r.PGP, other.PGP, strings.EqualFold)
+}
+
+// RenderCreationRules renders a .sops.yaml with one creation rule per entry,
+// listing fingerprints as a folded, comma-separated block.
+func RenderCreationRules(rules []Rule) []byte {
+	var b strings.Builder
+	b.WriteString("creation_rules:\n")
+	for i, rule := range rules {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("  - path_regex: " + yamlScalar(rule.PathRegex) + "\n")
+		b.WriteString("    pgp: >-\n")
+		for j, fp := range rule.PGP {
+			b.WriteString("      " + fp)
+			if j < len(rule.PGP)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// ParseCreationRules reads the PGP creation rules of a .sops.yaml.
+func ParseCreationRules(data []byte) ([]Rule, error) {
+	var file struct {
+		CreationRules []struct {
+			PathRegex string `yaml:"path_regex"`
+			PGP       string `yaml:"pgp"`
+		} `yaml:"creation_rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid sops config: %w", err)
+	}
+
+	rules := make([]Rule, 0, len(file.CreationRules))
+	for _, rule := range file.CreationRules {
+		rules = append(rules, Rule{PathRegex: rule.PathRegex, PGP: splitFingerprints(rule.PGP)})
+	}
+	return rules, nil
+}
+
+// yamlScalar encodes s as a single-line YAML scalar, quoting it when a plain
+// scalar would change its meaning.
+func yamlScalar(s string) string {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+	if strings.ContainsAny(s, "\n\r") {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return fmt.Sprintf("%q", s)
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+// Managed reports whether a .sops.yaml holds nothing but PGP creation rules,
+// without comments, so that RenderCreationRules can write it again without
+// losing anything. An empty file is managed.
+func Managed(data []byte) (bool, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false, fmt.Errorf("invalid sops config: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return !hasComments(&doc), nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode || hasComments(&doc) {
+		return false, nil
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "creation_rules" || root.Content[i+1].Kind != yaml.SequenceNode {
+			return false, nil
+		}
+		for _, entry := range root.Content[i+1].Content {
+			if !managedEntry(entry) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// EditCreationRules updates the creation_rules list of a .sops.yaml in
+// place. An entry whose path_regex is that of a rule gets the rule's PGP
+// fingerprints and keeps its other keys; an entry with only a path_regex and
+// pgp that no rule has is dropped; every other entry, such as an age or
+// key_groups rule, is left alone. Rules without an entry are inserted after
+// the entry of the rule before them, or, for the first rule, before the
+// entries of the others. It returns the number of entries left alone.
+func EditCreationRules(seq *yaml.Node, rules []Rule) (int, error) {
+	if seq.Kind != yaml.SequenceNode {
+		return 0, errors.New("creation_rules is not a list")
+	}
+
+	wanted := make(map[string]Rule, len(rules))
+	for _, rule := range rules {
+		wanted[rule.PathRegex] = rule
+	}
+
+	placed := make(map[string]*yaml.Node)
+	unmanaged := 0
+	content := seq.Content[:0]
+	for _, entry := range seq.Content {
+		regex := mappingValue(entry, "path_regex")
+		rule, ok := Rule{}, false
+		if regex != nil && placed[regex.Value] == nil {
+			rule, ok = wanted[regex.Value]
+		}
+		switch {
+		case ok:
+			setPGP(entry, rule)
+			placed[rule.PathRegex] = entry
+		case managedEntry(entry):
+			continue
+		default:
+			unmanaged++
+		}
+		content = append(content, entry)
+	}
+	seq.Content = content
+
+	for i, rule := range rules {
+		if placed[rule.PathRegex] != nil {
+			continue
+		}
+		at := len(seq.Content)
+		if i > 0 {
+			at = slices.Index(seq.Content, placed[rules[i-1].PathRegex]) + 1
+		} else if first := slices.IndexFunc(seq.Content, func(entry *yaml.Node) bool {
+			for _, node := range placed {
+				if node == entry {
+					return true
+				}
+			}
+			return false
+		}); first >= 0 {
+			at = first
+		}
+		entry := ruleNode(rule)
+		seq.Content = slices.Insert(seq.Content, at, entry)
+		placed[rule.PathRegex] = entry
+	}
+	return unmanaged, nil
+}
+
+// managedEntry reports whether a creation rule has only a path_regex and pgp.
+func managedEntry(entry *yaml.Node) bool {
+	if entry.Kind != yaml.MappingNode || len(entry.Content) != 4 {
+		return false
+	}
+	return mappingValue(entry, "path_regex") != nil && mappingValue(entry, "pgp") != nil
+}
+
+// setPGP sets the pgp key of a creation rule, leaving a value that already
+// lists the rule's fingerprints as it is.
+func setPGP(entry *yaml.Node, rule Rule) {
+	if pgp := mappingValue(entry, "pgp"); pgp != nil {
+		if (Rule{PathRegex: rule.PathRegex, PGP: splitFingerprints(pgp.Value)}).Equal(rule) {
+			return
+		}
+		*pgp = *mappingValue(ruleNode(rule), "pgp")
+		return
+	}
+	generated := ruleNode(rule)
+	entry.Content = append(entry.Content, generated.Content[2:4]...)
+}
+
+// ruleNode returns a creation rule as RenderCreationRules writes it.
+func ruleNode(rule Rule) *yaml.Node {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(RenderCreationRules([]Rule{rule}), &doc); err != nil {
+		panic(err)
+	}
+	return mappingValue(doc.Content[0], "creation_rules").Content[0]
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func hasComments(node *yaml.Node) bool {
+	if node.HeadComment != "" || node.LineComment != "" || node.FootComment != "" {
+		return true
+	}
+	return slices.ContainsFunc(node.Content, hasComments)
+}
+
+func splitFingerprints(value string) []string {
+	var fps []string
+	for _, fp := range strings.Split(value, ",") {
+		if fp = strings.TrimSpace(fp); fp != "" {
+			fps = append(fps, fp)
+		}
+	}
+	return fps
+}
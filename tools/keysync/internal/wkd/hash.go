@@ -0,0 +1,42 @@
+package wkd
+
+import (
+	"crypto/sha1"
+	"strings"
+)
+
+const zbase32Alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+// Hash returns the Web Key Directory hash of a mail address local-part: the
+// z-base-32 encoded SHA-1 of the lower-cased local-part.
+func Hash(localPart string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(localPart)))
+	return zbase32(sum[:])
+}
+
+// Path returns the direct-method path of the keys for a local-part, relative to
+// the domain's web root.
+func Path(localPart string) string {
+	return ".well-known/openpgpkey/hu/" + Hash(localPart)
+}
+
+// PolicyPath is the direct-method policy file that marks a domain as serving
+// a Web Key Directory.
+const PolicyPath = ".well-known/openpgpkey/policy"
+
+func zbase32(data []byte) string {
+	var b strings.Builder
+	var buffer, bits uint
+	for _, c := range data {
+		buffer = buffer<<8 | uint(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			b.WriteByte(zbase32Alphabet[(buffer>>bits)&31])
+		}
+	}
+	if bits > 0 {
+		b.WriteByte(zbase32Alphabet[(buffer<<(5-bits))&31])
+	}
+	return b.String()
+}